@@ -0,0 +1,134 @@
+package modbusone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiegeo/modbusone/crc"
+)
+
+// MaxASCIISize is the max possible size of a Modbus ASCII frame:
+// start, address, PDU, and LRC in hex, CR and LF.
+const MaxASCIISize = 1 + 2*(1+MaxPDUSize+1) + 2
+
+// asciiSerial translates between Modbus ASCII frames on a SerialContext and
+// RTU packets, so that RTUClient and RTUServer can be used for Modbus ASCII.
+type asciiSerial struct {
+	com SerialContext
+	r   *bufio.Reader
+}
+
+var (
+	_ SerialContext = &asciiSerial{}
+	_ PacketReader  = &asciiSerial{}
+)
+
+// NewASCIISerialContext wraps a SerialContext that sends and receives Modbus
+// ASCII frames, to be used as a SerialContext of RTU packets by RTUClient and
+// RTUServer. Stats are shared with com, where frames with a bad LRC are counted
+// as CrcErrors.
+func NewASCIISerialContext(com SerialContext) SerialContext {
+	return &asciiSerial{com: com, r: bufio.NewReaderSize(com, MaxASCIISize*2)}
+}
+
+// NewASCIIClient creates a new client communicating Modbus ASCII over
+// SerialContext with the given slaveID as default.
+func NewASCIIClient(com SerialContext, slaveID byte) *RTUClient {
+	return NewRTUClient(NewASCIISerialContext(com), slaveID)
+}
+
+// NewASCIIServer creates a Modbus ASCII server on SerialContext listening on slaveID.
+func NewASCIIServer(com SerialContext, slaveID byte) *RTUServer {
+	return NewRTUServer(NewASCIISerialContext(com), slaveID)
+}
+
+func (s *asciiSerial) PacketReaderFace() {}
+
+// Read reads an ASCII frame, and returns it as a RTU packet. If the LRC is
+// not valid, the CRC of the returned packet is not valid either.
+func (s *asciiSerial) Read(p []byte) (int, error) {
+	for {
+		line, err := s.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			atomic.AddInt64(&s.com.Stats().OtherDrops, 1)
+			debugf("ASCII frame too long, drop %v bytes", len(line))
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		atomic.AddInt64(&s.com.Stats().ReadPackets, 1)
+		rtu, err := asciiToRTU(line)
+		if err != nil {
+			atomic.AddInt64(&s.com.Stats().OtherDrops, 1)
+			debugf("ASCII drop frame %q: %v", line, err)
+			continue
+		}
+		return copy(p, rtu), nil
+	}
+}
+
+// asciiToRTU decodes an ASCII frame ending in LF to a RTU packet.
+func asciiToRTU(line []byte) (RTU, error) {
+	start := bytes.LastIndexByte(line, ':') // a start character always begins a new frame
+	if start < 0 {
+		return nil, fmt.Errorf("no start character")
+	}
+	line = line[start+1:]
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("frame not ended in CR LF")
+	}
+	line = line[:len(line)-2]
+	bs := make([]byte, hex.DecodedLen(len(line)), hex.DecodedLen(len(line))+1)
+	if _, err := hex.Decode(bs, line); err != nil {
+		return nil, err
+	}
+	if len(bs) < 3 {
+		return nil, fmt.Errorf("frame too short")
+	}
+	lrc := bs[len(bs)-1]
+	bs = bs[:len(bs)-1]
+	r := RTU(crc.Sum(bs))
+	if crc.LRC(bs) != lrc {
+		r[len(r)-1] ^= 0xFF // pass on as a CRC error
+	}
+	return r, nil
+}
+
+// Write writes a RTU packet as an ASCII frame.
+func (s *asciiSerial) Write(b []byte) (int, error) {
+	if len(b) < 3 {
+		return 0, fmt.Errorf("RTU packet too short")
+	}
+	body := crc.SumLRC(append([]byte(nil), b[:len(b)-2]...))
+	frame := make([]byte, 0, 1+hex.EncodedLen(len(body))+2)
+	frame = append(frame, ':')
+	frame = append(frame, bytes.ToUpper([]byte(hex.EncodeToString(body)))...)
+	frame = append(frame, '\r', '\n')
+	if _, err := s.com.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *asciiSerial) Close() error {
+	return s.com.Close()
+}
+
+// MinDelay returns 0, as ASCII frames are not delimited by silent intervals.
+func (s *asciiSerial) MinDelay() time.Duration {
+	return 0
+}
+
+// BytesDelay returns the duration to send an ASCII frame of a RTU packet of n bytes.
+func (s *asciiSerial) BytesDelay(n int) time.Duration {
+	return s.com.BytesDelay(2*n + 1)
+}
+
+func (s *asciiSerial) Stats() *Stats {
+	return s.com.Stats()
+}
@@ -0,0 +1,61 @@
+package modbusone_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestASCII(t *testing.T) {
+	slaveID := byte(0x11)
+	cc, sc := newMockSerialPair()
+	client := NewASCIIClient(cc, slaveID)
+	defer client.Close()
+	server := NewASCIIServer(sc, slaveID)
+	defer server.Close()
+
+	var got []uint16
+	go client.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			got = values
+			return nil
+		},
+	})
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return []uint16{0xAE41, 0x5652, 0x4340}[:quantity], nil
+		},
+	})
+
+	t.Run("read holding registers", func(t *testing.T) {
+		req, err := FcReadHoldingRegisters.MakeRequestHeader(0x6B, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DoTransaction(req); err != nil {
+			t.Fatal(err)
+		}
+		if s := string(cc.LastWritten); s != ":1103006B00037E\r\n" {
+			t.Errorf("request %q is not as expected", s)
+		}
+		if s := string(sc.LastWritten); s != ":110306AE4156524340CC\r\n" {
+			t.Errorf("reply %q is not as expected", s)
+		}
+		if len(got) != 3 || got[0] != 0xAE41 || got[2] != 0x4340 {
+			t.Errorf("got %x", got)
+		}
+	})
+	t.Run("bad lrc", func(t *testing.T) {
+		if _, err := cc.Writer.Write([]byte(":1103006B00037F\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; atomic.LoadInt64(&sc.Stats().CrcErrors) == 0; i++ {
+			if i > 100 {
+				t.Fatal("bad LRC is not counted as a CRC error")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package modbusone
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker tracks the health of each slave of an RTUClient, so an
+// unplugged slave does not make every transaction to it wait for a time out,
+// starving the other slaves on the bus.
+//
+// After Threshold consecutive transactions to a slave got no reply, the slave is
+// offline, and transactions to it fail fast with *SlaveOfflineError. The client
+// sends Probe to offline slaves every ProbeInterval while it is served, and any
+// reply from a slave brings it back online. A transaction of the application is
+// also sent as a probe if none was sent for ProbeInterval.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive time outs that takes a slave offline.
+	Threshold int
+	// ProbeInterval is the time between probes of an offline slave.
+	ProbeInterval time.Duration
+	// Probe is the request sent to probe offline slaves. An exception reply also
+	// brings a slave online. If nil, offline slaves are only probed by the
+	// transactions of the application.
+	Probe PDU
+	// OnOffline, if not nil, is called when a slave goes offline, with the time
+	// out error that took it offline.
+	OnOffline func(slaveID byte, err error)
+	// OnOnline, if not nil, is called when an offline slave replies again.
+	OnOnline func(slaveID byte)
+
+	lock   sync.Mutex
+	slaves map[byte]*slaveHealth // created on first use
+}
+
+// slaveHealth is the health of a slave in CircuitBreaker.
+type slaveHealth struct {
+	timeouts  int       // consecutive time outs
+	since     time.Time // when the slave went offline, zero while online
+	nextProbe time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker that takes slaves offline after
+// threshold consecutive time outs, and probes them every probeInterval by
+// reading the holding register at address 0.
+//
+// The callbacks are called by the Serve go routine of the client, so they must
+// return quickly, and must not wait for transactions of the client.
+func NewCircuitBreaker(threshold int, probeInterval time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold:     threshold,
+		ProbeInterval: probeInterval,
+		Probe:         PDU{byte(FcReadHoldingRegisters), 0, 0, 0, 1},
+	}
+}
+
+// Offline returns true if slaveID is offline.
+func (b *CircuitBreaker) Offline(slaveID byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	h, ok := b.slaves[slaveID]
+	return ok && !h.since.IsZero()
+}
+
+// offline returns the offline slaves.
+func (b *CircuitBreaker) offline() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var ids []byte
+	for id, h := range b.slaves {
+		if !h.since.IsZero() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// allow returns a *SlaveOfflineError if a transaction to slaveID should fail
+// fast at now, or nil to send it, which is a probe if the slave is offline.
+// Probes of probeOffline are always sent, as they are timed by its ticker.
+func (b *CircuitBreaker) allow(slaveID byte, now time.Time, probe bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	h, ok := b.slaves[slaveID]
+	if !ok || h.since.IsZero() {
+		return nil
+	}
+	if !probe && now.Before(h.nextProbe) {
+		return &SlaveOfflineError{SlaveID: slaveID, Since: h.since}
+	}
+	debugf("CircuitBreaker probe slave %v\n", slaveID)
+	h.nextProbe = now.Add(b.ProbeInterval)
+	return nil
+}
+
+// report updates the health of slaveID from the result of a transaction sent to
+// it.
+func (b *CircuitBreaker) report(slaveID byte, err error) {
+	timeout := errors.Is(err, ErrServerTimeOut) || errors.As(err, new(*SlaveIDMismatchError))
+	now := time.Now()
+	b.lock.Lock()
+	h, ok := b.slaves[slaveID]
+	if !ok {
+		if !timeout {
+			b.lock.Unlock()
+			return // healthy slaves are not tracked
+		}
+		if b.slaves == nil {
+			b.slaves = make(map[byte]*slaveHealth)
+		}
+		h = &slaveHealth{}
+		b.slaves[slaveID] = h
+	}
+	wasOffline := !h.since.IsZero()
+	if !timeout {
+		delete(b.slaves, slaveID)
+		b.lock.Unlock()
+		if wasOffline && b.OnOnline != nil {
+			b.OnOnline(slaveID)
+		}
+		return
+	}
+	h.timeouts++
+	goesOffline := !wasOffline && h.timeouts >= b.Threshold
+	if goesOffline {
+		h.since = now
+		h.nextProbe = now.Add(b.ProbeInterval)
+	}
+	b.lock.Unlock()
+	if goesOffline && b.OnOffline != nil {
+		b.OnOffline(slaveID, err)
+	}
+}
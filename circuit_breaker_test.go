@@ -0,0 +1,196 @@
+package modbusone_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	client, bus := connectMockBus(t, 1)
+	client.SetServerProcessingTime(time.Second / 20)
+	breaker := NewCircuitBreaker(2, time.Second/5)
+	breaker.Probe = nil // probe by the transactions of the test only
+	var lock sync.Mutex
+	var events []string
+	breaker.OnOffline = func(slaveID byte, err error) {
+		if !errors.Is(err, ErrServerTimeOut) {
+			t.Errorf("offline by %v", err)
+		}
+		lock.Lock()
+		events = append(events, "offline")
+		lock.Unlock()
+	}
+	breaker.OnOnline = func(slaveID byte) {
+		lock.Lock()
+		events = append(events, "online")
+		lock.Unlock()
+	}
+	checkEvents := func(t *testing.T, want ...string) {
+		t.Helper()
+		lock.Lock()
+		defer lock.Unlock()
+		if len(events) != len(want) {
+			t.Fatalf("got events %v, expected %v", events, want)
+		}
+		for i := range want {
+			if events[i] != want[i] {
+				t.Fatalf("got events %v, expected %v", events, want)
+			}
+		}
+	}
+	client.Breaker = breaker
+	go client.Serve(&SimpleHandler{})
+
+	var slave2Up int32
+	var sent [3]int64 // requests received by slave id
+	go func() {
+		b := make([]byte, MaxRTUSize)
+		for {
+			if _, err := bus.Read(b); err != nil {
+				return
+			}
+			atomic.AddInt64(&sent[b[0]], 1)
+			if b[0] == 1 || atomic.LoadInt32(&slave2Up) == 1 {
+				bus.Write(MakeRTU(b[0], PDU{3, 2, 0, 7}))
+			}
+		}
+	}()
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(slaveID byte) error {
+		_, err := client.DoRawTransaction(slaveID, req)
+		return err
+	}
+
+	t.Run("offline", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if err := do(2); !errors.Is(err, ErrServerTimeOut) {
+				t.Fatalf("got %v, expected time out", err)
+			}
+		}
+		checkEvents(t, "offline")
+		if !breaker.Offline(2) || breaker.Offline(1) {
+			t.Errorf("only slave 2 should be offline")
+		}
+		start := time.Now()
+		var oe *SlaveOfflineError
+		if err := do(2); !errors.As(err, &oe) || oe.SlaveID != 2 {
+			t.Fatalf("got %v, expected *SlaveOfflineError", err)
+		}
+		if d := time.Since(start); d > time.Second/40 {
+			t.Errorf("did not fail fast, took %v", d)
+		}
+		if got := atomic.LoadInt64(&sent[2]); got != 2 {
+			t.Errorf("slave 2 got %v requests, expected 2", got)
+		}
+		if err := do(1); err != nil {
+			t.Errorf("slave 1: %v", err)
+		}
+	})
+	t.Run("failed probe", func(t *testing.T) {
+		time.Sleep(time.Second / 5)
+		if err := do(2); !errors.Is(err, ErrServerTimeOut) {
+			t.Fatalf("got %v, expected time out of the probe", err)
+		}
+		if err := do(2); !errors.As(err, new(*SlaveOfflineError)) {
+			t.Fatalf("got %v, expected *SlaveOfflineError", err)
+		}
+		if got := atomic.LoadInt64(&sent[2]); got != 3 {
+			t.Errorf("slave 2 got %v requests, expected 3", got)
+		}
+		checkEvents(t, "offline")
+	})
+	t.Run("online", func(t *testing.T) {
+		atomic.StoreInt32(&slave2Up, 1)
+		time.Sleep(time.Second / 5)
+		if err := do(2); err != nil {
+			t.Fatal(err)
+		}
+		checkEvents(t, "offline", "online")
+		if breaker.Offline(2) {
+			t.Error("slave 2 should be online")
+		}
+		if err := do(2); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("probe rate", func(t *testing.T) {
+		client, bus := connectMockBus(t, 1)
+		client.SetServerProcessingTime(time.Second / 100)
+		client.Breaker = NewCircuitBreaker(1, time.Second/20)
+		go client.Serve(&SimpleHandler{})
+		var probes int64
+		go func() {
+			b := make([]byte, MaxRTUSize)
+			for {
+				if _, err := bus.Read(b); err != nil {
+					return
+				}
+				atomic.AddInt64(&probes, 1) // never replied
+			}
+		}()
+		if _, err := client.DoRawTransaction(3, req); !errors.Is(err, ErrServerTimeOut) {
+			t.Fatalf("got %v, expected time out", err)
+		}
+		atomic.StoreInt64(&probes, 0)
+		time.Sleep(time.Second)
+		if n := atomic.LoadInt64(&probes); n < 17 || n > 21 {
+			t.Errorf("got %v probes in 1 second, expected 20", n)
+		}
+	})
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	client, bus := connectMockBus(t, 1)
+	client.SetServerProcessingTime(time.Second / 20)
+	online := make(chan byte, 1)
+	client.Breaker = &CircuitBreaker{ // not by NewCircuitBreaker
+		Threshold:     1,
+		ProbeInterval: time.Second / 10,
+		Probe:         PDU{byte(FcReadInputRegisters), 0, 0, 0, 1},
+		OnOnline:      func(slaveID byte) { online <- slaveID },
+	}
+	go client.Serve(&SimpleHandler{})
+
+	var up int32
+	go func() {
+		b := make([]byte, MaxRTUSize)
+		for {
+			if _, err := bus.Read(b); err != nil {
+				return
+			}
+			if atomic.LoadInt32(&up) == 1 {
+				bus.Write(MakeRTU(b[0], PDU{0x80 | b[1], byte(EcIllegalDataAddress)}))
+			}
+		}
+	}()
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DoRawTransaction(1, req); !errors.Is(err, ErrServerTimeOut) {
+		t.Fatalf("got %v, expected time out", err)
+	}
+	if !client.Breaker.Offline(1) {
+		t.Fatal("slave 1 should be offline")
+	}
+	atomic.StoreInt32(&up, 1)
+	select {
+	case id := <-online:
+		if id != 1 {
+			t.Errorf("slave %v online, expected 1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slave 1 was not probed back online")
+	}
+	if client.Breaker.Offline(1) {
+		t.Error("slave 1 should be online")
+	}
+}
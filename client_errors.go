@@ -0,0 +1,110 @@
+package modbusone
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// ExceptionError is returned by clients when a server replies with an
+// exception. It unwraps to Code, so errors.As(err, &ExceptionCode) and
+// errors.Is(err, EcIllegalDataAddress) work.
+type ExceptionError struct {
+	SlaveID      byte
+	FunctionCode FunctionCode // of the request
+	Code         ExceptionCode
+}
+
+// exceptionReplyError returns the error of an exception reply rp from slaveID.
+func exceptionReplyError(slaveID byte, rp PDU) *ExceptionError {
+	_, fc := rp.GetFunctionCode().SeparateError()
+	ec := EcInternal
+	if len(rp) > 1 {
+		ec = ExceptionCode(rp[1])
+	}
+	return &ExceptionError{SlaveID: slaveID, FunctionCode: fc, Code: ec}
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("server %v reply to function code %v with exception: %v", e.SlaveID, e.FunctionCode, e.Code)
+}
+
+// Unwrap returns Code.
+func (e *ExceptionError) Unwrap() error {
+	return e.Code
+}
+
+// CRCError is returned by RTU clients when the reply to a request fails the CRC
+// check. It unwraps to ErrorCrc.
+type CRCError struct {
+	SlaveID      byte
+	FunctionCode FunctionCode // of the request
+}
+
+func (e *CRCError) Error() string {
+	return fmt.Sprintf("reply from server %v to function code %v: %v", e.SlaveID, e.FunctionCode, ErrorCrc)
+}
+
+// Unwrap returns ErrorCrc.
+func (e *CRCError) Unwrap() error {
+	return ErrorCrc
+}
+
+// SlaveIDMismatchError is returned by clients when the reply to a request is
+// from another slave. TCP clients return it at once. RTU clients share the bus
+// with other slaves, so they keep waiting for the expected slave, and return it
+// instead of a TimeoutError if none replied.
+type SlaveIDMismatchError struct {
+	SlaveID      byte // of the request
+	FunctionCode FunctionCode
+	Got          byte // of the reply
+}
+
+func (e *SlaveIDMismatchError) Error() string {
+	return fmt.Sprintf("reply to function code %v from server %v, expected server %v", e.FunctionCode, e.Got, e.SlaveID)
+}
+
+// UnexpectedReplyError is returned by clients when a reply from the expected
+// slave does not answer the request.
+type UnexpectedReplyError struct {
+	SlaveID byte
+	Request PDU
+	Reply   PDU
+}
+
+func (e *UnexpectedReplyError) Error() string {
+	return fmt.Sprintf("unexpected reply from server %v to %v: %v",
+		e.SlaveID, hex.EncodeToString(e.Request), hex.EncodeToString(e.Reply))
+}
+
+// TimeoutError is returned by clients when a request is not replied in time. It
+// unwraps to ErrServerTimeOut.
+type TimeoutError struct {
+	SlaveID      byte
+	FunctionCode FunctionCode // of the request
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("server %v timed out on function code %v", e.SlaveID, e.FunctionCode)
+}
+
+// Unwrap returns ErrServerTimeOut.
+func (e *TimeoutError) Unwrap() error {
+	return ErrServerTimeOut
+}
+
+// Timeout returns true, as in net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// SlaveOfflineError is returned by an RTUClient with a CircuitBreaker, without
+// sending the request, when the slave is offline.
+type SlaveOfflineError struct {
+	SlaveID byte
+	Since   time.Time // when the slave went offline
+}
+
+func (e *SlaveOfflineError) Error() string {
+	return fmt.Sprintf("server %v is offline since %v", e.SlaveID, e.Since.Format(time.RFC3339))
+}
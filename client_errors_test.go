@@ -0,0 +1,155 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+// clientErrorCase is a reply and the typed error it causes. A nil reply is
+// not sent.
+type clientErrorCase struct {
+	name  string
+	reply []byte
+	check func(t *testing.T, err error)
+}
+
+func checkException(t *testing.T, err error) {
+	t.Helper()
+	var ee *ExceptionError
+	if !errors.As(err, &ee) {
+		t.Fatalf("got %v, expected *ExceptionError", err)
+	}
+	if ee.SlaveID != 1 || ee.FunctionCode != FcReadHoldingRegisters || ee.Code != EcIllegalDataAddress {
+		t.Errorf("got %+v", *ee)
+	}
+	var ec ExceptionCode
+	if !errors.As(err, &ec) || ec != EcIllegalDataAddress {
+		t.Errorf("got exception code %v from %v", ec, err)
+	}
+}
+
+func checkMismatch(t *testing.T, err error) {
+	t.Helper()
+	var me *SlaveIDMismatchError
+	if !errors.As(err, &me) {
+		t.Fatalf("got %v, expected *SlaveIDMismatchError", err)
+	}
+	if me.SlaveID != 1 || me.Got != 2 || me.FunctionCode != FcReadHoldingRegisters {
+		t.Errorf("got %+v", *me)
+	}
+}
+
+func checkUnexpected(t *testing.T, err error) {
+	t.Helper()
+	var ue *UnexpectedReplyError
+	if !errors.As(err, &ue) {
+		t.Fatalf("got %v, expected *UnexpectedReplyError", err)
+	}
+	if ue.SlaveID != 1 || ue.Reply.GetFunctionCode() != FcReadInputRegisters {
+		t.Errorf("got %+v", *ue)
+	}
+}
+
+func checkTimeout(t *testing.T, err error) {
+	t.Helper()
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("got %v, expected *TimeoutError", err)
+	}
+	if te.SlaveID != 1 || te.FunctionCode != FcReadHoldingRegisters {
+		t.Errorf("got %+v", *te)
+	}
+	if !errors.Is(err, ErrServerTimeOut) {
+		t.Errorf("%v is not ErrServerTimeOut", err)
+	}
+}
+
+func TestRTUClientErrors(t *testing.T) {
+	crcFailed := MakeRTU(1, PDU{3, 2, 0, 0})
+	crcFailed[len(crcFailed)-1]++
+	cases := []clientErrorCase{
+		{"exception", MakeRTU(1, PDU{0x83, 2}), checkException},
+		{"crc", crcFailed, func(t *testing.T, err error) {
+			var ce *CRCError
+			if !errors.As(err, &ce) {
+				t.Fatalf("got %v, expected *CRCError", err)
+			}
+			if ce.SlaveID != 1 || ce.FunctionCode != FcReadHoldingRegisters {
+				t.Errorf("got %+v", *ce)
+			}
+			if !errors.Is(err, ErrorCrc) {
+				t.Errorf("%v is not ErrorCrc", err)
+			}
+		}},
+		{"slave id mismatch", MakeRTU(2, PDU{3, 2, 0, 0}), checkMismatch},
+		{"unexpected reply", MakeRTU(1, PDU{4, 2, 0, 0}), checkUnexpected},
+		{"time out", nil, checkTimeout},
+	}
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, bus := connectMockBus(t, 1)
+	client.SetServerProcessingTime(time.Second / 10)
+	go client.Serve(&SimpleHandler{})
+	replies := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, MaxRTUSize)
+		for {
+			if _, err := bus.Read(b); err != nil {
+				return
+			}
+			if reply := <-replies; reply != nil {
+				bus.Write(reply)
+			}
+		}
+	}()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			replies <- tc.reply
+			tc.check(t, client.DoTransactionContext(context.Background(), 1, req))
+		})
+	}
+}
+
+func TestTCPClientErrors(t *testing.T) {
+	cases := []clientErrorCase{
+		{"exception", []byte{1, 0x83, 2}, checkException},
+		{"slave id mismatch", []byte{2, 3, 2, 0, 0}, checkMismatch},
+		{"unexpected reply", []byte{1, 4, 2, 0, 0}, checkUnexpected},
+		{"time out", nil, checkTimeout},
+	}
+	cc, sc := net.Pipe()
+	client := NewTCPClient(cc, 1)
+	client.Timeout = time.Second / 10
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+	replies := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 12)
+		for {
+			if _, err := io.ReadFull(sc, b); err != nil {
+				return
+			}
+			if reply := <-replies; reply != nil {
+				sc.Write(append([]byte{b[0], b[1], 0, 0, 0, byte(len(reply))}, reply...))
+			}
+		}
+	}()
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			replies <- tc.reply
+			tc.check(t, client.DoTransactionContext(context.Background(), 1, req))
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package modbusone
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CommEvent is an entry of the communication event log of a serial server,
+// as returned by FcGetCommEventLog.
+//
+// A CommEvent is either a receive event, a send event, or one of
+// CommEventRestart and CommEventEnteredListenOnly. Receive and send events
+// use the same bits for different meanings, so the flags should only be
+// tested after IsReceive or IsSend.
+type CommEvent byte
+
+// Defined CommEvents and flags.
+const (
+	CommEventRestart           CommEvent = 0x00 // communication restart
+	CommEventEnteredListenOnly CommEvent = 0x04 // server entered listen only mode
+
+	CommEventReceive            CommEvent = 0x80 // set for all receive events
+	CommEventReceiveCommError   CommEvent = 0x02 // receive flag: CRC or other communication error
+	CommEventReceiveOverrun     CommEvent = 0x10 // receive flag: character overrun
+	CommEventReceiveListenOnly  CommEvent = 0x20 // receive flag: currently in listen only mode
+	CommEventReceiveBroadcast   CommEvent = 0x40 // receive flag: broadcast received
+	CommEventSend               CommEvent = 0x40 // set for all send events
+	CommEventSendReadException  CommEvent = 0x01 // send flag: exception code 1-3 sent
+	CommEventSendAbortException CommEvent = 0x02 // send flag: exception code 4 sent
+	CommEventSendBusyException  CommEvent = 0x04 // send flag: exception code 5-6 sent
+	CommEventSendNAKException   CommEvent = 0x08 // send flag: exception code 7 sent
+	CommEventSendWriteTimeout   CommEvent = 0x10 // send flag: write timeout error occurred
+	CommEventSendListenOnly     CommEvent = 0x20 // send flag: currently in listen only mode
+
+	commEventSendMask CommEvent = 0xC0
+	commEventLogSize            = 64
+)
+
+// IsReceive returns true for receive events.
+func (e CommEvent) IsReceive() bool {
+	return e&CommEventReceive != 0
+}
+
+// IsSend returns true for send events.
+func (e CommEvent) IsSend() bool {
+	return e&commEventSendMask == CommEventSend
+}
+
+// Has returns true if all bits of flag are set in e.
+func (e CommEvent) Has(flag CommEvent) bool {
+	return e&flag == flag
+}
+
+func (e CommEvent) String() string {
+	var names []string
+	flag := func(f CommEvent, name string) {
+		if e.Has(f) {
+			names = append(names, name)
+		}
+	}
+	switch {
+	case e.IsReceive():
+		names = append(names, "receive")
+		flag(CommEventReceiveCommError, "comm error")
+		flag(CommEventReceiveOverrun, "overrun")
+		flag(CommEventReceiveListenOnly, "listen only")
+		flag(CommEventReceiveBroadcast, "broadcast")
+	case e.IsSend():
+		names = append(names, "send")
+		flag(CommEventSendReadException, "read exception")
+		flag(CommEventSendAbortException, "abort exception")
+		flag(CommEventSendBusyException, "busy exception")
+		flag(CommEventSendNAKException, "NAK exception")
+		flag(CommEventSendWriteTimeout, "write timeout")
+		flag(CommEventSendListenOnly, "listen only")
+	case e == CommEventRestart:
+		return "restart"
+	case e == CommEventEnteredListenOnly:
+		return "entered listen only"
+	default:
+		return fmt.Sprintf("CommEvent:0x%02X", byte(e))
+	}
+	return strings.Join(names, ",")
+}
+
+// commEventSendFlag returns the send event flag for sending exception code e.
+func commEventSendFlag(e ExceptionCode) CommEvent {
+	switch e {
+	case EcIllegalFunction, EcIllegalDataAddress, EcIllegalDataValue:
+		return CommEventSendReadException
+	case EcServerDeviceFailure:
+		return CommEventSendAbortException
+	case EcAcknowledge, EcServerDeviceBusy:
+		return CommEventSendBusyException
+	case 7: // negative acknowledge, not used by this package
+		return CommEventSendNAKException
+	}
+	return 0
+}
+
+// commEventLog is the comm event counter and the ring of the last 64 events of a server.
+type commEventLog struct {
+	lock    sync.Mutex
+	counter uint16
+	events  [commEventLogSize]CommEvent
+	next    int // index for the next event
+	size    int // number of events in the log
+}
+
+// add adds an event to the log.
+func (l *commEventLog) add(e CommEvent) {
+	l.lock.Lock()
+	l.events[l.next] = e
+	l.next = (l.next + 1) % commEventLogSize
+	if l.size < commEventLogSize {
+		l.size++
+	}
+	l.lock.Unlock()
+}
+
+// count increments the event counter, after a successful message completion.
+func (l *commEventLog) count() {
+	l.lock.Lock()
+	l.counter++
+	l.lock.Unlock()
+}
+
+func (l *commEventLog) clearCounter() {
+	l.lock.Lock()
+	l.counter = 0
+	l.lock.Unlock()
+}
+
+func (l *commEventLog) clearEvents() {
+	l.lock.Lock()
+	l.size = 0
+	l.lock.Unlock()
+}
+
+// get returns the event counter and the events, most recent first.
+func (l *commEventLog) get() (uint16, []CommEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	events := make([]CommEvent, l.size)
+	for i := range events {
+		events[i] = l.events[(l.next-1-i+commEventLogSize)%commEventLogSize]
+	}
+	return l.counter, events
+}
+
+// CommEvents returns the comm event counter and the comm event log, most recent first.
+func (s *RTUServer) CommEvents() (uint16, []CommEvent) {
+	return s.events.get()
+}
+
+// handleCommEvents answers FcGetCommEventCounter and FcGetCommEventLog requests.
+// Status is always 0, as the server does not process requests in the background.
+func (s *RTUServer) handleCommEvents(p PDU) (PDU, error) {
+	if len(p) != 1 {
+		return nil, EcIllegalDataValue
+	}
+	counter, events := s.events.get()
+	if p.GetFunctionCode() == FcGetCommEventCounter {
+		return PDU{p[0], 0, 0, byte(counter >> 8), byte(counter)}, nil
+	}
+	messages := s.DiagnosticCounters().BusMessages
+	reply := PDU{p[0], byte(6 + len(events)), 0, 0,
+		byte(counter >> 8), byte(counter), byte(messages >> 8), byte(messages)}
+	for _, e := range events {
+		reply = append(reply, byte(e))
+	}
+	return reply, nil
+}
+
+// CommEventLog is the content of a FcGetCommEventLog reply.
+type CommEventLog struct {
+	Status       uint16
+	EventCount   uint16
+	MessageCount uint16
+	Events       []CommEvent // most recent first
+}
+
+// GetCommEventCounter reads the status and the comm event counter from a serial server.
+func GetCommEventCounter(c RawTransactor, slaveID byte) (status, count uint16, err error) {
+	rp, err := c.DoRawTransaction(slaveID, PDU{byte(FcGetCommEventCounter)})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rp) != 5 {
+		return 0, 0, fmt.Errorf("comm event counter reply of %v bytes, expected 5", len(rp))
+	}
+	return binary.BigEndian.Uint16(rp[1:]), binary.BigEndian.Uint16(rp[3:]), nil
+}
+
+// GetCommEventLog reads the comm event log from a serial server.
+func GetCommEventLog(c RawTransactor, slaveID byte) (*CommEventLog, error) {
+	rp, err := c.DoRawTransaction(slaveID, PDU{byte(FcGetCommEventLog)})
+	if err != nil {
+		return nil, err
+	}
+	if len(rp) < 8 || int(rp[1]) != len(rp)-2 {
+		return nil, fmt.Errorf("comm event log reply length mismatch:%x", []byte(rp))
+	}
+	log := &CommEventLog{
+		Status:       binary.BigEndian.Uint16(rp[2:]),
+		EventCount:   binary.BigEndian.Uint16(rp[4:]),
+		MessageCount: binary.BigEndian.Uint16(rp[6:]),
+		Events:       make([]CommEvent, len(rp)-8),
+	}
+	for i, e := range rp[8:] {
+		log.Events[i] = CommEvent(e)
+	}
+	return log, nil
+}
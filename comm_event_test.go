@@ -0,0 +1,99 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestCommEvents(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, cc := connectMockRTU(t, slaveID)
+
+	go client.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+	})
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return make([]uint16, quantity), nil
+		},
+	})
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Diagnostics(client, slaveID, DiagRestartCommunications, []byte{0xFF, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DoTransaction(read); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Diagnostics(client, slaveID, 0x0100, []byte{0, 0}); err == nil {
+		t.Fatal("expected exception for unsupported sub-function")
+	}
+
+	t.Run("counter", func(t *testing.T) {
+		status, count, err := GetCommEventCounter(client, slaveID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cc.LastWritten, RTU{0x11, 0x0B, 0x4C, 0x27}) {
+			t.Errorf("request %x is not as expected", cc.LastWritten)
+		}
+		// the restart and the read are counted, the exception is not
+		if status != 0 || count != 2 {
+			t.Errorf("got status %x count %v, expected 0 and 2", status, count)
+		}
+	})
+	t.Run("log", func(t *testing.T) {
+		log, err := GetCommEventLog(client, slaveID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cc.LastWritten, RTU{0x11, 0x0C, 0x0D, 0xE5}) {
+			t.Errorf("request %x is not as expected", cc.LastWritten)
+		}
+		if log.EventCount != 2 {
+			t.Errorf("got event count %v, expected 2", log.EventCount)
+		}
+		if log.MessageCount < 4 {
+			t.Errorf("got message count %v, expected at least 4", log.MessageCount)
+		}
+		want := []CommEvent{
+			CommEventReceive, // get comm event log
+			CommEventSend,    // get comm event counter
+			CommEventReceive, // get comm event counter
+			CommEventSend | CommEventSendReadException, // unsupported sub-function
+			CommEventReceive, // unsupported sub-function
+			CommEventSend,    // read
+			CommEventReceive, // read
+			CommEventSend,    // restart
+			CommEventRestart, // restart, log cleared before
+		}
+		if !reflect.DeepEqual(log.Events, want) {
+			t.Errorf("got events %v, expected %v", log.Events, want)
+		}
+	})
+	t.Run("full log", func(t *testing.T) {
+		for i := 0; i < 40; i++ {
+			if _, _, err := GetCommEventCounter(client, slaveID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		log, err := GetCommEventLog(client, slaveID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(log.Events) != 64 {
+			t.Errorf("got %v events, expected 64", len(log.Events))
+		}
+		if log.EventCount != 2 {
+			t.Errorf("got event count %v, expected 2", log.EventCount)
+		}
+	})
+}
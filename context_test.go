@@ -0,0 +1,145 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestContextWithdraw(t *testing.T) {
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := io.Pipe()
+	defer w.Close()
+	com := newMockSerial("c", r, w, w)
+	clients := map[string]interface {
+		DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error
+	}{
+		"rtu":      NewRTUClient(com, 1),
+		"failover": NewFailoverRTUClient(com, false, 1),
+	}
+	for name, c := range clients {
+		t.Run(name, func(t *testing.T) {
+			// the clients are not served, so the transaction can not be started.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+			defer cancel()
+			if err := c.DoTransactionContext(ctx, 1, req); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got %v, expected deadline exceeded", err)
+			}
+		})
+	}
+}
+
+func TestRTUClientContext(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, _ := connectMockRTU(t, slaveID)
+	client.SetServerProcessingTime(time.Second)
+
+	slow := make(chan struct{}, 1)
+	go client.Serve(&SimpleHandler{})
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			select {
+			case <-slow:
+				time.Sleep(time.Second / 5)
+			default:
+			}
+			return make([]uint16, quantity), nil
+		},
+	})
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := client.DoRawTransactionContext(ctx, slaveID, req); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, expected canceled", err)
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		slow <- struct{}{}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+		defer cancel()
+		start := time.Now()
+		if _, err := client.DoRawTransactionContext(ctx, slaveID, req); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, expected deadline exceeded", err)
+		}
+		if d := time.Since(start); d > time.Second/2 {
+			t.Errorf("deadline did not shorten the time out, took %v", d)
+		}
+	})
+	t.Run("after", func(t *testing.T) {
+		// transactions started while Serve waits for an abandoned reply must wait
+		// for their turn, instead of stopping Serve.
+		for i := 0; i < 3; i++ {
+			slow <- struct{}{}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+			if _, err := client.DoRawTransactionContext(ctx, slaveID, req); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got %v, expected deadline exceeded", err)
+			}
+			cancel()
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second*2)
+			_, err := client.DoRawTransactionContext(ctx, slaveID, req)
+			cancel()
+			if err != nil {
+				t.Fatalf("iteration %v: %v", i, err)
+			}
+		}
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+				defer cancel()
+				_, err := client.DoRawTransactionContext(ctx, slaveID, req)
+				errs <- err
+			}()
+		}
+		for i := 0; i < cap(errs); i++ {
+			if err := <-errs; err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
+
+func TestTCPClientContext(t *testing.T) {
+	cc, sc := net.Pipe()
+	client := NewTCPClient(cc, 1)
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+	go func() {
+		b := make([]byte, 256)
+		for {
+			if _, err := sc.Read(b); err != nil {
+				return // never replies
+			}
+		}
+	}()
+
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Second/20, cancel)
+	if err := client.DoTransactionContext(ctx, 1, req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected canceled", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+	if _, err := client.DoRawTransactionContext(ctx, 1, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, expected deadline exceeded", err)
+	}
+}
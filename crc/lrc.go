@@ -0,0 +1,24 @@
+package crc
+
+// LRC returns the Longitudinal Redundancy Check of bs, as used by Modbus ASCII:
+// the two's complement of the 8 bit sum of all bytes.
+func LRC(bs []byte) byte {
+	var sum byte
+	for _, b := range bs {
+		sum += b
+	}
+	return -sum
+}
+
+// ValidateLRC return true if byte slice ends with valid LRC.
+func ValidateLRC(bs []byte) bool {
+	if len(bs) <= 1 {
+		return false
+	}
+	return LRC(bs) == 0
+}
+
+// SumLRC appends the LRC of input to it and returns the resulting slice.
+func SumLRC(bs []byte) []byte {
+	return append(bs, LRC(bs))
+}
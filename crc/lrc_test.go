@@ -0,0 +1,29 @@
+package crc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestLRC(t *testing.T) {
+	testCases := [][]byte{
+		// from http://www.simplymodbus.ca/ASCII.htm
+		{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x7E},
+		{0xF7, 0x03, 0x13, 0x89, 0x00, 0x0A, 0x60},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%v:%v", i, hex.EncodeToString(tc)), func(t *testing.T) {
+			if !ValidateLRC(tc) {
+				t.Fatal("lrc invalid")
+			}
+			l := len(tc)
+			b := tc[l-1]
+			tc = SumLRC(tc[:l-1])
+			if b != tc[l-1] {
+				t.Fatalf("lrc calculation failed %x != %x", b, tc[l-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package modbusone
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CustomFunction defines a user-defined or vendor specific function code,
+// such as the user-defined codes 65 to 72 and 100 to 110, registered with
+// RegisterFunction.
+//
+// Clients send requests of custom function codes with DoRawTransaction.
+type CustomFunction struct {
+	// SizeFromHeader returns the expected size of a request (isClient is false)
+	// or a reply (isClient is true) PDU with the given PDU header, starting with
+	// the function code. If not enough info is in the header, then it returns
+	// the shortest possible. It is required.
+	SizeFromHeader func(header []byte, isClient bool) int
+
+	// ServerHandler answers a request on servers, and returns the reply PDU.
+	// An error is sent back as an exception reply, and a nil PDU without error
+	// is not replied. If nil, servers reply with EcIllegalFunction.
+	ServerHandler func(req PDU) (PDU, error)
+}
+
+var customFunctions = struct {
+	sync.RWMutex
+	m map[FunctionCode]CustomFunction
+}{m: make(map[FunctionCode]CustomFunction)}
+
+// RegisterFunction registers a custom function code, or replaces the previous
+// registration. The packet readers, servers, and clients of this package honor
+// the registration. Function codes implemented by this package can not be
+// registered.
+func RegisterFunction(fc FunctionCode, cf CustomFunction) error {
+	if fc == 0 || fc > 0x7f || fc.isStandard() {
+		return fmt.Errorf("function code %v can not be registered", fc)
+	}
+	if cf.SizeFromHeader == nil {
+		return fmt.Errorf("function code %v registered without SizeFromHeader", fc)
+	}
+	customFunctions.Lock()
+	customFunctions.m[fc] = cf
+	customFunctions.Unlock()
+	return nil
+}
+
+// UnregisterFunction removes the registration of a custom function code.
+func UnregisterFunction(fc FunctionCode) {
+	customFunctions.Lock()
+	delete(customFunctions.m, fc)
+	customFunctions.Unlock()
+}
+
+// customFunction returns the registration of fc, if any.
+func customFunction(fc FunctionCode) (CustomFunction, bool) {
+	customFunctions.RLock()
+	cf, ok := customFunctions.m[fc]
+	customFunctions.RUnlock()
+	return cf, ok
+}
+
+// handleCustomFunction answers a request of a registered custom function code.
+func handleCustomFunction(cf CustomFunction, p PDU) (PDU, error) {
+	if cf.ServerHandler == nil {
+		return nil, ErrFcNotSupported
+	}
+	return cf.ServerHandler(p)
+}
@@ -0,0 +1,62 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestCustomFunction(t *testing.T) {
+	fc := FunctionCode(0x41) // user-defined function code 65
+	if fc.Valid() {
+		t.Fatal("unregistered function code is valid")
+	}
+	if err := RegisterFunction(FcReadCoils, CustomFunction{SizeFromHeader: GetPDUSizeFromHeader}); err == nil {
+		t.Fatal("expected error for registering a standard function code")
+	}
+	err := RegisterFunction(fc, CustomFunction{
+		SizeFromHeader: func(header []byte, isClient bool) int {
+			if !isClient {
+				return 3 // fc, 2 bytes
+			}
+			if len(header) < 2 {
+				return 2
+			}
+			return 2 + int(header[1]) // fc, byte count, data
+		},
+		ServerHandler: func(req PDU) (PDU, error) {
+			if len(req) != 3 {
+				return nil, EcIllegalDataValue
+			}
+			return PDU{req[0], 2, req[2], req[1]}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterFunction(fc)
+	if !fc.Valid() {
+		t.Fatal("registered function code is not valid")
+	}
+
+	slaveID := byte(0x11)
+	client, server, cc := connectMockRTU(t, slaveID)
+
+	go client.Serve(&SimpleHandler{})
+	go server.Serve(&SimpleHandler{})
+
+	rp, err := client.DoRawTransaction(slaveID, PDU{byte(fc), 0x12, 0x34})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cc.LastWritten, RTU{0x11, 0x41, 0x12, 0x34, 0x58, 0x7B}) {
+		t.Errorf("request %x is not as expected", cc.LastWritten)
+	}
+	if !bytes.Equal(rp, PDU{byte(fc), 2, 0x34, 0x12}) {
+		t.Errorf("got reply %x", rp)
+	}
+	if GetRTUSizeFromHeader([]byte{0x11, 0x41, 5}, true) != 2+5+3 {
+		t.Error("reply size is not from the registration")
+	}
+}
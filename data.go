@@ -105,3 +105,9 @@ func RegistersToData(values []uint16) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// MaskRegister applies the masks of a FcMaskWriteRegister request to the current
+// value of a register, and returns the new value to be stored.
+func MaskRegister(current, andMask, orMask uint16) uint16 {
+	return (current & andMask) | (orMask &^ andMask)
+}
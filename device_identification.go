@@ -0,0 +1,253 @@
+package modbusone
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MEIReadDeviceIdentification is the MEI type of FcEncapsulatedInterface for
+// Read Device Identification.
+const MEIReadDeviceIdentification = 0x0E
+
+// ReadDeviceIDCode selects the access type of a Read Device Identification request.
+type ReadDeviceIDCode byte
+
+// Defined ReadDeviceIDCodes.
+const (
+	ReadDeviceIDBasic      ReadDeviceIDCode = 1 // stream access of basic objects
+	ReadDeviceIDRegular    ReadDeviceIDCode = 2 // stream access of basic and regular objects
+	ReadDeviceIDExtended   ReadDeviceIDCode = 3 // stream access of all objects
+	ReadDeviceIDIndividual ReadDeviceIDCode = 4 // individual access of one object
+)
+
+// DeviceIDObjectID identifies an object of Read Device Identification.
+type DeviceIDObjectID byte
+
+// Defined DeviceIDObjectIDs, 0x07 to 0x7F are reserved, and 0x80 to 0xFF are
+// extended objects that are device dependent.
+const (
+	ObjectVendorName          DeviceIDObjectID = 0x00
+	ObjectProductCode         DeviceIDObjectID = 0x01
+	ObjectMajorMinorRevision  DeviceIDObjectID = 0x02
+	ObjectVendorURL           DeviceIDObjectID = 0x03
+	ObjectProductName         DeviceIDObjectID = 0x04
+	ObjectModelName           DeviceIDObjectID = 0x05
+	ObjectUserApplicationName DeviceIDObjectID = 0x06
+)
+
+// lastObjectID returns the last object id that can be streamed with code.
+func (code ReadDeviceIDCode) lastObjectID() DeviceIDObjectID {
+	switch code {
+	case ReadDeviceIDBasic:
+		return ObjectMajorMinorRevision
+	case ReadDeviceIDRegular:
+		return 0x7F
+	}
+	return 0xFF
+}
+
+// deviceIDReplyHeaderLength is the length of the reply before the list of objects.
+const deviceIDReplyHeaderLength = 7
+
+// MaxDeviceIDObjectLength is the longest object value that can fit in a reply.
+const MaxDeviceIDObjectLength = MaxPDUSize - deviceIDReplyHeaderLength - 2
+
+// DeviceIdentity is the object store used by a server to answer Read Device
+// Identification (FC43/14) requests. The zero value is an empty store ready
+// to use.
+//
+// VendorName, ProductCode and MajorMinorRevision are mandatory for a compliant
+// server, and should always be set.
+type DeviceIdentity struct {
+	lock    sync.RWMutex
+	objects map[DeviceIDObjectID]string
+}
+
+// Set sets the value of an object. Values longer than MaxDeviceIDObjectLength
+// are rejected.
+func (d *DeviceIdentity) Set(id DeviceIDObjectID, value string) error {
+	if len(value) > MaxDeviceIDObjectLength {
+		return fmt.Errorf("object %v of %v bytes is too long", id, len(value))
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.objects == nil {
+		d.objects = make(map[DeviceIDObjectID]string)
+	}
+	d.objects[id] = value
+	return nil
+}
+
+// Get returns the value of an object, and if it is set.
+func (d *DeviceIdentity) Get(id DeviceIDObjectID) (string, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	v, ok := d.objects[id]
+	return v, ok
+}
+
+// ConformityLevel returns the conformity level reported to clients, which
+// depends on the objects that are set. Individual access is always supported.
+func (d *DeviceIdentity) ConformityLevel() byte {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.conformityLevel()
+}
+
+func (d *DeviceIdentity) conformityLevel() byte {
+	level := byte(ReadDeviceIDBasic)
+	for id := range d.objects {
+		if id > ReadDeviceIDRegular.lastObjectID() {
+			level = byte(ReadDeviceIDExtended)
+			break
+		}
+		if id > ReadDeviceIDBasic.lastObjectID() {
+			level = byte(ReadDeviceIDRegular)
+		}
+	}
+	return level | 0x80
+}
+
+// handleRequest answers a FcEncapsulatedInterface request.
+func (d *DeviceIdentity) handleRequest(p PDU) (PDU, error) {
+	if d == nil || len(p) < 2 || p[1] != MEIReadDeviceIdentification {
+		return nil, ErrFcNotSupported
+	}
+	if len(p) != 4 {
+		return nil, EcIllegalDataValue
+	}
+	code := ReadDeviceIDCode(p[2])
+	id := DeviceIDObjectID(p[3])
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	reply := PDU{p[0], p[1], p[2], d.conformityLevel(), 0, 0, 0}
+	add := func(id DeviceIDObjectID, v string) {
+		reply = append(reply, byte(id), byte(len(v)))
+		reply = append(reply, v...)
+		reply[6]++
+	}
+	switch code {
+	default:
+		return nil, EcIllegalDataValue
+	case ReadDeviceIDIndividual:
+		v, ok := d.objects[id]
+		if !ok {
+			return nil, EcIllegalDataAddress
+		}
+		add(id, v)
+		return reply, nil
+	case ReadDeviceIDBasic, ReadDeviceIDRegular, ReadDeviceIDExtended:
+	}
+	last := code.lastObjectID()
+	if _, ok := d.objects[id]; !ok || id > last {
+		id = 0 // restart from the beginning for unknown objects
+	}
+	for ; ; id++ {
+		if v, ok := d.objects[id]; ok {
+			if len(reply)+2+len(v) > MaxPDUSize {
+				reply[4] = 0xFF // more follows
+				reply[5] = byte(id)
+				return reply, nil
+			}
+			add(id, v)
+		}
+		if id == last {
+			return reply, nil
+		}
+	}
+}
+
+// getDeviceIDReplySize returns the expected size of a Read Device Identification
+// reply, walking the list of objects as far as the header allows.
+func getDeviceIDReplySize(header []byte) int {
+	if len(header) < deviceIDReplyHeaderLength {
+		return deviceIDReplyHeaderLength
+	}
+	size := deviceIDReplyHeaderLength
+	for i := 0; i < int(header[6]); i++ {
+		if len(header) < size+2 {
+			return size + 2
+		}
+		size += 2 + int(header[size+1])
+	}
+	return size
+}
+
+// DeviceIDReply is the content of Read Device Identification replies.
+type DeviceIDReply struct {
+	Code            ReadDeviceIDCode
+	ConformityLevel byte
+	MoreFollows     bool
+	NextObjectID    DeviceIDObjectID
+	Objects         map[DeviceIDObjectID]string
+}
+
+// GetDeviceIDReply parses a Read Device Identification reply.
+func (p PDU) GetDeviceIDReply() (*DeviceIDReply, error) {
+	if p.GetFunctionCode() != FcEncapsulatedInterface || len(p) < 2 || p[1] != MEIReadDeviceIdentification {
+		return nil, fmt.Errorf("not a device identification reply:%x", []byte(p))
+	}
+	if getDeviceIDReplySize(p) != len(p) {
+		return nil, fmt.Errorf("length mismatch with objects")
+	}
+	r := &DeviceIDReply{
+		Code:            ReadDeviceIDCode(p[2]),
+		ConformityLevel: p[3],
+		MoreFollows:     p[4] == 0xFF,
+		NextObjectID:    DeviceIDObjectID(p[5]),
+		Objects:         make(map[DeviceIDObjectID]string),
+	}
+	pos := deviceIDReplyHeaderLength
+	for i := 0; i < int(p[6]); i++ {
+		l := int(p[pos+1])
+		r.Objects[DeviceIDObjectID(p[pos])] = string(p[pos+2 : pos+2+l])
+		pos += 2 + l
+	}
+	return r, nil
+}
+
+// MakeDeviceIDRequest makes a Read Device Identification request.
+func MakeDeviceIDRequest(code ReadDeviceIDCode, objectID DeviceIDObjectID) PDU {
+	return PDU{byte(FcEncapsulatedInterface), MEIReadDeviceIdentification, byte(code), byte(objectID)}
+}
+
+// ErrDeviceIDStream is returned when a server does not advance the object stream.
+var ErrDeviceIDStream = errors.New("device identification stream did not advance")
+
+// ReadDeviceIdentification reads device identification objects from a server,
+// starting from objectID. For stream access, requests are repeated until the
+// server reports no more objects follow, and Objects of the returned reply
+// collects the objects of all replies. For ReadDeviceIDIndividual, only the
+// object objectID is read.
+func ReadDeviceIdentification(c RawTransactor, slaveID byte, code ReadDeviceIDCode, objectID DeviceIDObjectID) (*DeviceIDReply, error) {
+	var all *DeviceIDReply
+	for i := 0; ; i++ {
+		rp, err := c.DoRawTransaction(slaveID, MakeDeviceIDRequest(code, objectID))
+		if err != nil {
+			return all, err
+		}
+		r, err := rp.GetDeviceIDReply()
+		if err != nil {
+			return all, err
+		}
+		if all == nil {
+			all = r
+		} else {
+			for id, v := range r.Objects {
+				all.Objects[id] = v
+			}
+			all.MoreFollows = r.MoreFollows
+			all.NextObjectID = r.NextObjectID
+		}
+		if !r.MoreFollows || code == ReadDeviceIDIndividual {
+			return all, nil
+		}
+		if len(r.Objects) == 0 || i > 0xFF {
+			return all, ErrDeviceIDStream
+		}
+		objectID = r.NextObjectID
+	}
+}
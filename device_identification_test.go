@@ -0,0 +1,85 @@
+package modbusone_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestReadDeviceIdentification(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, _ := connectMockRTU(t, slaveID)
+
+	id := &DeviceIdentity{}
+	want := map[DeviceIDObjectID]string{
+		ObjectVendorName:         "Company identification",
+		ObjectProductCode:        "Product code XX",
+		ObjectMajorMinorRevision: "V2.11",
+		ObjectProductName:        "Product name",
+	}
+	for i := DeviceIDObjectID(0x80); i < 0x84; i++ {
+		// extended objects too large to fit in one reply
+		want[i] = strings.Repeat(string(rune('a'+i-0x80)), 100)
+	}
+	for k, v := range want {
+		if err := id.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.DeviceIdentity = id
+
+	go client.Serve(&SimpleHandler{})
+	go server.Serve(&SimpleHandler{})
+
+	t.Run("basic", func(t *testing.T) {
+		r, err := ReadDeviceIdentification(client, slaveID, ReadDeviceIDBasic, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ConformityLevel != 0x83 {
+			t.Errorf("got conformity level %x, expected 83", r.ConformityLevel)
+		}
+		if len(r.Objects) != 3 {
+			t.Errorf("got %v objects, expected 3", len(r.Objects))
+		}
+		for k, v := range r.Objects {
+			if want[k] != v {
+				t.Errorf("object %v got %q, expected %q", k, v, want[k])
+			}
+		}
+	})
+	t.Run("extended", func(t *testing.T) {
+		r, err := ReadDeviceIdentification(client, slaveID, ReadDeviceIDExtended, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Objects) != len(want) {
+			t.Errorf("got %v objects, expected %v", len(r.Objects), len(want))
+		}
+		for k, v := range want {
+			if r.Objects[k] != v {
+				t.Errorf("object %v got %q, expected %q", k, r.Objects[k], v)
+			}
+		}
+	})
+	t.Run("individual", func(t *testing.T) {
+		r, err := ReadDeviceIdentification(client, slaveID, ReadDeviceIDIndividual, ObjectProductName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Objects) != 1 || r.Objects[ObjectProductName] != want[ObjectProductName] {
+			t.Errorf("got %v", r.Objects)
+		}
+	})
+	t.Run("individual missing", func(t *testing.T) {
+		_, err := ReadDeviceIdentification(client, slaveID, ReadDeviceIDIndividual, ObjectModelName)
+		if err == nil {
+			t.Fatal("expected exception")
+		}
+		if errors.Is(err, ErrServerTimeOut) {
+			t.Fatal(err)
+		}
+	})
+}
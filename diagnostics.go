@@ -0,0 +1,217 @@
+package modbusone
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// DiagnosticSubFunction is the sub-function code of a FcDiagnostics request.
+type DiagnosticSubFunction uint16
+
+// Supported DiagnosticSubFunctions.
+const (
+	DiagReturnQueryData                  DiagnosticSubFunction = 0x00
+	DiagRestartCommunications            DiagnosticSubFunction = 0x01
+	DiagReturnDiagnosticRegister         DiagnosticSubFunction = 0x02
+	DiagForceListenOnlyMode              DiagnosticSubFunction = 0x04
+	DiagClearCounters                    DiagnosticSubFunction = 0x0A
+	DiagReturnBusMessageCount            DiagnosticSubFunction = 0x0B
+	DiagReturnBusCommunicationErrorCount DiagnosticSubFunction = 0x0C
+	DiagReturnBusExceptionErrorCount     DiagnosticSubFunction = 0x0D
+	DiagReturnServerMessageCount         DiagnosticSubFunction = 0x0E
+	DiagReturnServerNoResponseCount      DiagnosticSubFunction = 0x0F
+	DiagReturnServerBusyCount            DiagnosticSubFunction = 0x11
+)
+
+// DiagnosticCounters are the counters of a RTUServer, as returned by FcDiagnostics.
+type DiagnosticCounters struct {
+	BusMessages            int64 // packets read, from Stats.ReadPackets
+	BusCommunicationErrors int64 // packets with CRC errors, from Stats.CrcErrors
+	BusExceptionErrors     int64 // exception replies sent
+	ServerMessages         int64 // requests addressed to this server, including multicast
+	ServerNoResponses      int64 // requests addressed to this server that were not replied
+	ServerBusy             int64 // EcServerDeviceBusy replies sent
+}
+
+// diagnostics keeps the state of a RTUServer for FcDiagnostics.
+type diagnostics struct {
+	// baselines of Stats when counters were last cleared
+	readPackets int64
+	crcErrors   int64
+
+	exceptions     int64
+	serverMessages int64
+	noResponses    int64
+	busy           int64
+
+	listenOnly int32
+}
+
+func (d *diagnostics) counters(stats *Stats) DiagnosticCounters {
+	return DiagnosticCounters{
+		BusMessages:            atomic.LoadInt64(&stats.ReadPackets) - atomic.LoadInt64(&d.readPackets),
+		BusCommunicationErrors: atomic.LoadInt64(&stats.CrcErrors) - atomic.LoadInt64(&d.crcErrors),
+		BusExceptionErrors:     atomic.LoadInt64(&d.exceptions),
+		ServerMessages:         atomic.LoadInt64(&d.serverMessages),
+		ServerNoResponses:      atomic.LoadInt64(&d.noResponses),
+		ServerBusy:             atomic.LoadInt64(&d.busy),
+	}
+}
+
+// clear clears all counters, Stats is not changed.
+func (d *diagnostics) clear(stats *Stats) {
+	atomic.StoreInt64(&d.readPackets, atomic.LoadInt64(&stats.ReadPackets))
+	atomic.StoreInt64(&d.crcErrors, atomic.LoadInt64(&stats.CrcErrors))
+	atomic.StoreInt64(&d.exceptions, 0)
+	atomic.StoreInt64(&d.serverMessages, 0)
+	atomic.StoreInt64(&d.noResponses, 0)
+	atomic.StoreInt64(&d.busy, 0)
+}
+
+func (d *diagnostics) isListenOnly() bool {
+	return atomic.LoadInt32(&d.listenOnly) != 0
+}
+
+func (d *diagnostics) setListenOnly(on bool) {
+	if on {
+		atomic.StoreInt32(&d.listenOnly, 1)
+	} else {
+		atomic.StoreInt32(&d.listenOnly, 0)
+	}
+}
+
+// countException counts an exception reply of code e.
+func (d *diagnostics) countException(e ExceptionCode) {
+	atomic.AddInt64(&d.exceptions, 1)
+	if e == EcServerDeviceBusy {
+		atomic.AddInt64(&d.busy, 1)
+	}
+}
+
+// DiagnosticCounters returns the current diagnostic counters.
+func (s *RTUServer) DiagnosticCounters() DiagnosticCounters {
+	return s.diag.counters(s.com.Stats())
+}
+
+// IsListenOnly returns true if the server is in listen only mode, entered by
+// a DiagForceListenOnlyMode request. No requests are answered until the
+// server receives a DiagRestartCommunications request.
+func (s *RTUServer) IsListenOnly() bool {
+	return s.diag.isListenOnly()
+}
+
+// handleDiagnostics answers a FcDiagnostics request. A nil PDU with no error
+// is returned if there should be no reply.
+func (s *RTUServer) handleDiagnostics(p PDU) (PDU, error) {
+	if len(p) < 5 || len(p)%2 != 1 {
+		return nil, EcIllegalDataValue
+	}
+	sub := DiagnosticSubFunction(binary.BigEndian.Uint16(p[1:]))
+	data := binary.BigEndian.Uint16(p[3:])
+	if sub != DiagReturnQueryData && len(p) != 5 {
+		return nil, EcIllegalDataValue
+	}
+	counter := func(v int64) (PDU, error) {
+		return PDU{p[0], p[1], p[2], byte(v >> 8), byte(v)}, nil
+	}
+	c := s.DiagnosticCounters()
+	switch sub {
+	case DiagReturnQueryData:
+		return p, nil
+	case DiagRestartCommunications:
+		if data != 0 && data != 0xFF00 {
+			return nil, EcIllegalDataValue
+		}
+		wasListenOnly := s.diag.isListenOnly()
+		s.diag.setListenOnly(false)
+		s.diag.clear(s.com.Stats())
+		s.events.clearCounter()
+		if data == 0xFF00 {
+			s.events.clearEvents()
+		}
+		s.events.add(CommEventRestart)
+		if wasListenOnly {
+			return nil, nil
+		}
+		return p, nil
+	case DiagReturnDiagnosticRegister:
+		return counter(0)
+	case DiagForceListenOnlyMode:
+		s.diag.setListenOnly(true)
+		s.events.add(CommEventEnteredListenOnly)
+		return nil, nil
+	case DiagClearCounters:
+		s.diag.clear(s.com.Stats())
+		s.events.clearCounter()
+		return p, nil
+	case DiagReturnBusMessageCount:
+		return counter(c.BusMessages)
+	case DiagReturnBusCommunicationErrorCount:
+		return counter(c.BusCommunicationErrors)
+	case DiagReturnBusExceptionErrorCount:
+		return counter(c.BusExceptionErrors)
+	case DiagReturnServerMessageCount:
+		return counter(c.ServerMessages)
+	case DiagReturnServerNoResponseCount:
+		return counter(c.ServerNoResponses)
+	case DiagReturnServerBusyCount:
+		return counter(c.ServerBusy)
+	}
+	return nil, ErrFcNotSupported
+}
+
+// isRestartCommunications returns true if p is a DiagRestartCommunications request.
+func (p PDU) isRestartCommunications() bool {
+	return len(p) == 5 && p.GetFunctionCode() == FcDiagnostics &&
+		DiagnosticSubFunction(binary.BigEndian.Uint16(p[1:])) == DiagRestartCommunications
+}
+
+// MakeDiagnosticsRequest makes a FcDiagnostics request, data must be 2 bytes.
+//
+// DiagReturnQueryData could echo more data, but the length of FcDiagnostics
+// packets is not encoded, and serial packet readers frame them as 2 bytes of
+// data.
+func MakeDiagnosticsRequest(sub DiagnosticSubFunction, data []byte) (PDU, error) {
+	if len(data) != 2 {
+		return nil, fmt.Errorf("diagnostics sub-function %v can not send %v bytes", sub, len(data))
+	}
+	return append(PDU{byte(FcDiagnostics), byte(sub >> 8), byte(sub)}, data...), nil
+}
+
+// Diagnostics sends a FcDiagnostics request and returns the data of the reply.
+//
+// DiagForceListenOnlyMode is never replied, so ErrServerTimeOut is returned as
+// nil for it. A server in listen only mode does not reply to anything, including
+// the DiagRestartCommunications request that ends listen only mode.
+func Diagnostics(c RawTransactor, slaveID byte, sub DiagnosticSubFunction, data []byte) ([]byte, error) {
+	req, err := MakeDiagnosticsRequest(sub, data)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := c.DoRawTransaction(slaveID, req)
+	if sub == DiagForceListenOnlyMode && errors.Is(err, ErrServerTimeOut) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rp) < 3 {
+		return nil, fmt.Errorf("diagnostics reply too short:%x", []byte(rp))
+	}
+	return rp[3:], nil
+}
+
+// ReadDiagnosticCounter reads a counter (or the diagnostic register) from a
+// server with the sub-function sub, such as DiagReturnBusMessageCount.
+func ReadDiagnosticCounter(c RawTransactor, slaveID byte, sub DiagnosticSubFunction) (uint16, error) {
+	data, err := Diagnostics(c, slaveID, sub, []byte{0, 0})
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 2 {
+		return 0, fmt.Errorf("diagnostics reply of %v bytes, expected 2", len(data))
+	}
+	return binary.BigEndian.Uint16(data), nil
+}
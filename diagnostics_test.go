@@ -0,0 +1,105 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestDiagnostics(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, cc := connectMockRTU(t, slaveID)
+	client.SetServerProcessingTime(time.Second / 20)
+
+	sh := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return make([]uint16, quantity), nil
+		},
+	}
+	go client.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+	})
+	go server.Serve(sh)
+
+	counter := func(sub DiagnosticSubFunction) uint16 {
+		t.Helper()
+		v, err := ReadDiagnosticCounter(client, slaveID, sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("return query data", func(t *testing.T) {
+		data, err := Diagnostics(client, slaveID, DiagReturnQueryData, []byte{0xA5, 0x37})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, []byte{0xA5, 0x37}) {
+			t.Errorf("got %x", data)
+		}
+		if !bytes.Equal(cc.LastWritten, RTU{0x11, 0x08, 0x00, 0x00, 0xA5, 0x37, 0xD8, 0x1D}) {
+			t.Errorf("request %x is not as expected", cc.LastWritten)
+		}
+		if _, err := MakeDiagnosticsRequest(DiagReturnQueryData, []byte{1, 2, 3, 4}); err == nil {
+			t.Error("expected error for data that can not be framed")
+		}
+	})
+	t.Run("counters", func(t *testing.T) {
+		if _, err := Diagnostics(client, slaveID, DiagClearCounters, []byte{0, 0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DoTransaction(read); err != nil {
+			t.Fatal(err)
+		}
+		// the clear counters request is not counted, the read and this request is
+		if got := counter(DiagReturnServerMessageCount); got != 2 {
+			t.Errorf("server message count %v, expected 2", got)
+		}
+		if got := counter(DiagReturnBusExceptionErrorCount); got != 0 {
+			t.Errorf("exception count %v, expected 0", got)
+		}
+		if _, err := Diagnostics(client, slaveID, 0x0100, []byte{0, 0}); err == nil {
+			t.Fatal("expected exception for unsupported sub-function")
+		}
+		if got := counter(DiagReturnBusExceptionErrorCount); got != 1 {
+			t.Errorf("exception count %v, expected 1", got)
+		}
+		if got := server.DiagnosticCounters().BusMessages; got < 5 {
+			t.Errorf("bus message count %v, expected at least 5", got)
+		}
+	})
+	t.Run("listen only", func(t *testing.T) {
+		if _, err := Diagnostics(client, slaveID, DiagForceListenOnlyMode, []byte{0, 0}); err != nil {
+			t.Fatal(err)
+		}
+		if !server.IsListenOnly() {
+			t.Fatal("server is not in listen only mode")
+		}
+		if err := client.DoTransaction(read); !errors.Is(err, ErrServerTimeOut) {
+			t.Fatalf("got %v, expected time out in listen only mode", err)
+		}
+		_, err := Diagnostics(client, slaveID, DiagRestartCommunications, []byte{0, 0})
+		if !errors.Is(err, ErrServerTimeOut) {
+			t.Fatalf("got %v, expected no reply to restart from listen only mode", err)
+		}
+		if server.IsListenOnly() {
+			t.Fatal("server is still in listen only mode")
+		}
+		if err := client.DoTransaction(read); err != nil {
+			t.Fatal(err)
+		}
+		if got := counter(DiagReturnServerMessageCount); got != 2 {
+			t.Errorf("server message count %v, expected 2 after restart", got)
+		}
+	})
+}
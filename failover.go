@@ -342,6 +342,14 @@ func IsRequestReply(r, a PDU) bool {
 			debugf("diff fc\n")
 			return false
 		}
+		if r.GetFunctionCode() == FcDiagnostics {
+			// sizes are not known from headers, replies echo the request
+			// except for the data of counters.
+			if len(r) < 5 || len(a) < 5 || !bytes.Equal(r[:3], a[:3]) {
+				return false
+			}
+			return len(r) == len(a) && (len(r) == 5 || bytes.Equal(r, a))
+		}
 		if GetPDUSizeFromHeader(r, false) != len(r) {
 			debugf("r size not req %v, %x\n", GetPDUSizeFromHeader(r, true), r)
 			return false
@@ -350,20 +358,38 @@ func IsRequestReply(r, a PDU) bool {
 			debugf("a size not rep %v, %x\n", GetPDUSizeFromHeader(a, false), a)
 			return false
 		}
-		c, err := r.GetRequestCount()
-		if err != nil {
-			debugf("GetRequestCount error %v\n", err)
-			return false
+		if _, ok := customFunction(r.GetFunctionCode()); ok {
+			return true // sizes are all a registration knows
 		}
 		eq := false
 		switch r.GetFunctionCode() {
-		case FcReadCoils, FcReadDiscreteInputs:
-			eq = uint8((c+7)/8) == a[1]
-		case FcReadHoldingRegisters, FcReadInputRegisters:
-			eq = uint8(c*2) == a[1]
+		case FcReadCoils, FcReadDiscreteInputs,
+			FcReadHoldingRegisters, FcReadInputRegisters, FcReadWriteMultipleRegisters:
+			c, err := r.GetRequestCount()
+			if err != nil {
+				debugf("GetRequestCount error %v\n", err)
+				return false
+			}
+			if r.GetFunctionCode().IsBool() {
+				eq = uint8((c+7)/8) == a[1]
+			} else {
+				eq = uint8(c*2) == a[1]
+			}
 		case FcWriteSingleCoil, FcWriteSingleRegister,
 			FcWriteMultipleCoils, FcWriteMultipleRegisters:
 			eq = bytes.Equal(r[:5], a[:5])
+		case FcMaskWriteRegister:
+			eq = bytes.Equal(r[:7], a[:7])
+		case FcReadFileRecord:
+			eq = true // a is framed by its byte count
+		case FcWriteFileRecord:
+			eq = bytes.Equal(r, a)
+		case FcReadFIFOQueue, FcReadExceptionStatus, FcGetCommEventCounter,
+			FcGetCommEventLog, FcReportServerID:
+			eq = true // a is framed by its byte count, there is nothing else to match
+		case FcEncapsulatedInterface:
+			// same MEI type and read device id code
+			eq = bytes.Equal(r[1:3], a[1:3])
 		}
 		if !eq {
 			debugf("header mismatch\n")
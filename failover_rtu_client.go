@@ -2,6 +2,7 @@ package modbusone
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -111,7 +112,7 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 		}
 		defer last.Reset()
 
-		if pdu.GetFunctionCode().IsWriteToServer() {
+		if !pdu.GetFunctionCode().IsReadToServer() {
 			// no-op for us
 			return
 		}
@@ -122,7 +123,7 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 			otherwise()
 			return
 		}
-		err = handler.OnWrite(last.Bytes(), bs)
+		err = handler.OnWrite(PDU(last.Bytes()).readPart(), bs)
 		if err != nil {
 			debugf("readUnexpected OnWrite error: %v", err)
 			otherwise()
@@ -130,8 +131,14 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 		}
 	}
 
+	var queued []rtuAction // started while waiting for a reply
 	for {
-		act := <-c.actions
+		var act rtuAction
+		if len(queued) > 0 {
+			act, queued = queued[0], queued[1:]
+		} else {
+			act = <-c.actions
+		}
 		switch act.t {
 		default:
 			readUnexpected(act, func() {
@@ -143,10 +150,14 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 			return act.err
 		case clientStart:
 		}
+		if err := act.ctxErr(); err != nil {
+			act.errChan <- err // abandoned before sending
+			continue
+		}
 		ap := act.data.fastGetPDU()
 		afc := ap.GetFunctionCode()
 		if afc.IsWriteToServer() {
-			data, err := handler.OnRead(ap)
+			data, err := handler.OnRead(ap.writePart())
 			if err != nil {
 				act.errChan <- err
 				continue
@@ -171,13 +182,21 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 		}
 
 		timeOutChan := time.After(c.GetTransactionTimeOut(len(act.data), MaxRTUSize))
+		var mismatch error // a reply from another slave, returned on time out
 
 	READ_LOOP:
 		for {
 		SELECT:
 			select {
 			case <-timeOutChan:
-				act.errChan <- ErrServerTimeOut
+				if mismatch != nil {
+					act.errChan <- mismatch
+					break READ_LOOP
+				}
+				act.errChan <- &TimeoutError{SlaveID: act.data[0], FunctionCode: afc}
+				break READ_LOOP
+			case <-act.done():
+				act.errChan <- act.ctxErr()
 				break READ_LOOP
 			case react := <-c.actions:
 				switch react.t {
@@ -185,6 +204,9 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 					err := fmt.Errorf("unexpected action:%s", react.t)
 					act.errChan <- err
 					return err
+				case clientStart:
+					queued = append(queued, react) // run after this transaction
+					break SELECT
 				case clientError:
 					return react.err
 				case clientRead:
@@ -196,12 +218,14 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 				if react.data[0] != act.data[0] {
 					atomic.AddInt64(&c.com.Stats().IDDrops, 1)
 					debugf("FailoverRTUClient unexpected slaveId:%v in %v\n", act.data[0], hex.EncodeToString(react.data))
+					mismatch = &SlaveIDMismatchError{SlaveID: act.data[0], FunctionCode: afc, Got: react.data[0]}
 					break SELECT
 				}
 				rp, err := react.data.GetPDU()
 				if err != nil {
 					if errors.Is(err, ErrorCrc) {
 						atomic.AddInt64(&c.com.Stats().CrcErrors, 1)
+						err = &CRCError{SlaveID: act.data[0], FunctionCode: afc}
 					} else {
 						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
 					}
@@ -212,14 +236,14 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 				if hasErr && fc == afc {
 					atomic.AddInt64(&c.com.Stats().RemoteErrors, 1)
 					handler.OnError(ap, rp)
-					act.errChan <- fmt.Errorf("server reply with exception:%v", hex.EncodeToString(rp))
+					act.errChan <- exceptionReplyError(act.data[0], rp)
 					break READ_LOOP
 				}
 				if !IsRequestReply(act.data.fastGetPDU(), rp) {
 					readUnexpected(act, func() {
 						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
 					})
-					act.errChan <- fmt.Errorf("unexpected reply:%v", hex.EncodeToString(rp))
+					act.errChan <- &UnexpectedReplyError{SlaveID: act.data[0], Request: ap, Reply: rp}
 					break READ_LOOP
 				}
 				if afc.IsReadToServer() {
@@ -230,7 +254,7 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 						act.errChan <- err
 						break READ_LOOP
 					}
-					err = handler.OnWrite(ap, bs)
+					err = handler.OnWrite(ap.readPart(), bs)
 					if err != nil {
 						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
 					}
@@ -273,3 +297,10 @@ func (c *FailoverRTUClient) DoTransaction(req PDU) error {
 func (c *FailoverRTUClient) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
 	c.actions <- rtuAction{t: clientStart, data: MakeRTU(slaveID, req), errChan: errChan}
 }
+
+// DoTransactionContext is DoTransaction with a settable slaveID and a context.
+// The transaction is withdrawn if ctx is done before it is sent, and the wait
+// for a reply ends when ctx is done.
+func (c *FailoverRTUClient) DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error {
+	return doActionContext(ctx, c.actions, rtuAction{t: clientStart, data: MakeRTU(slaveID, req)})
+}
@@ -0,0 +1,370 @@
+package modbusone
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// FileRecordReferenceType is the only reference type of file record sub-requests.
+const FileRecordReferenceType = 6
+
+// MaxFileRecordNumber is the largest record number in a file.
+const MaxFileRecordNumber = 0x270F
+
+const (
+	fileRecordSubRequestSize = 7 // reference type, file number, record number, record length
+	// maxFileRecordRead is the most registers that fit in one FcReadFileRecord reply.
+	maxFileRecordRead = (MaxPDUSize - 2 - 2) / 2
+	// maxFileRecordWrite is the most registers that fit in one FcWriteFileRecord request.
+	maxFileRecordWrite = (MaxPDUSize - 2 - fileRecordSubRequestSize) / 2
+)
+
+var _ FileRecordHandler = &SimpleHandler{} // Asserts SimpleHandler implants FileRecordHandler.
+
+// FileRecordHandler can be implemented by a ProtocolHandler given to a server,
+// to answer FcReadFileRecord and FcWriteFileRecord requests. The handler is
+// called once for each sub-request.
+type FileRecordHandler interface {
+	// OnReadFileRecord is called on the server for a read file record sub-request,
+	// exactly length registers must be returned.
+	OnReadFileRecord(file, record, length uint16) ([]uint16, error)
+	// OnWriteFileRecord is called on the server for a write file record sub-request.
+	OnWriteFileRecord(file, record uint16, values []uint16) error
+}
+
+// FileRecordRef references length registers from record in file, as in a
+// FcReadFileRecord sub-request.
+type FileRecordRef struct {
+	File   uint16
+	Record uint16
+	Length uint16
+}
+
+// FileRecord is the registers from record in file, as in a FcWriteFileRecord
+// sub-request, or the result of reading a FileRecordRef.
+type FileRecord struct {
+	File   uint16
+	Record uint16
+	Values []uint16
+}
+
+// validate checks if the file record reference is in range.
+func (r FileRecordRef) validate() error {
+	if r.File == 0 || r.Length == 0 || uint32(r.Record)+uint32(r.Length) > MaxFileRecordNumber+1 {
+		debugf("file record reference out of range:%+v\n", r)
+		return EcIllegalDataAddress
+	}
+	return nil
+}
+
+// MakeReadFileRecordRequest makes a FcReadFileRecord request with a sub-request
+// for each FileRecordRef.
+func MakeReadFileRecordRequest(refs []FileRecordRef) (PDU, error) {
+	size := 2 + len(refs)*fileRecordSubRequestSize
+	replySize := 2
+	for _, r := range refs {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%+v: %w", r, err)
+		}
+		replySize += 2 + 2*int(r.Length)
+	}
+	if len(refs) == 0 || size > MaxPDUSize || replySize > MaxPDUSize {
+		return nil, fmt.Errorf("%v file record references do not fit in one packet", len(refs))
+	}
+	p := make(PDU, 2, size)
+	p[0] = byte(FcReadFileRecord)
+	p[1] = byte(size - 2)
+	for _, r := range refs {
+		p = append(p, FileRecordReferenceType,
+			byte(r.File>>8), byte(r.File),
+			byte(r.Record>>8), byte(r.Record),
+			byte(r.Length>>8), byte(r.Length))
+	}
+	return p, nil
+}
+
+// MakeWriteFileRecordRequest makes a FcWriteFileRecord request with a sub-request
+// for each FileRecord.
+func MakeWriteFileRecordRequest(records []FileRecord) (PDU, error) {
+	size := 2
+	for _, r := range records {
+		if err := (FileRecordRef{r.File, r.Record, uint16(len(r.Values))}).validate(); err != nil || len(r.Values) > maxFileRecordWrite {
+			return nil, fmt.Errorf("file %v record %v of %v registers can not be written", r.File, r.Record, len(r.Values))
+		}
+		size += fileRecordSubRequestSize + 2*len(r.Values)
+	}
+	if len(records) == 0 || size > MaxPDUSize {
+		return nil, fmt.Errorf("%v file records do not fit in one packet", len(records))
+	}
+	p := make(PDU, 2, size)
+	p[0] = byte(FcWriteFileRecord)
+	p[1] = byte(size - 2)
+	for _, r := range records {
+		l := len(r.Values)
+		p = append(p, FileRecordReferenceType,
+			byte(r.File>>8), byte(r.File),
+			byte(r.Record>>8), byte(r.Record),
+			byte(l>>8), byte(l))
+		data, _ := RegistersToData(r.Values)
+		p = append(p, data...)
+	}
+	return p, nil
+}
+
+// getFileRecordData checks the byte count of a file record PDU, and returns the
+// data after it.
+func (p PDU) getFileRecordData(fc FunctionCode) ([]byte, error) {
+	if p.GetFunctionCode() != fc || len(p) < 2 || int(p[1]) != len(p)-2 {
+		debugf("file record PDU length mismatch:%x\n", []byte(p))
+		return nil, EcIllegalDataValue
+	}
+	return p[2:], nil
+}
+
+// GetReadFileRecordRequest returns the sub-requests of a FcReadFileRecord request.
+func (p PDU) GetReadFileRecordRequest() ([]FileRecordRef, error) {
+	data, err := p.getFileRecordData(FcReadFileRecord)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%fileRecordSubRequestSize != 0 {
+		return nil, EcIllegalDataValue
+	}
+	refs := make([]FileRecordRef, 0, len(data)/fileRecordSubRequestSize)
+	for ; len(data) > 0; data = data[fileRecordSubRequestSize:] {
+		if data[0] != FileRecordReferenceType {
+			return nil, EcIllegalDataAddress
+		}
+		r := FileRecordRef{
+			File:   binary.BigEndian.Uint16(data[1:]),
+			Record: binary.BigEndian.Uint16(data[3:]),
+			Length: binary.BigEndian.Uint16(data[5:]),
+		}
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	return refs, nil
+}
+
+// GetReadFileRecordReply returns the registers of each sub-response of a
+// FcReadFileRecord reply.
+func (p PDU) GetReadFileRecordReply() ([][]uint16, error) {
+	data, err := p.getFileRecordData(FcReadFileRecord)
+	if err != nil {
+		return nil, err
+	}
+	var values [][]uint16
+	for len(data) > 0 {
+		l := int(data[0]) // reference type and data
+		if l < 3 || l%2 != 1 || len(data) < 1+l || data[1] != FileRecordReferenceType {
+			return nil, fmt.Errorf("file record sub-response malformed:%x", data)
+		}
+		v, err := DataToRegisters(data[2 : 1+l])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		data = data[1+l:]
+	}
+	return values, nil
+}
+
+// GetWriteFileRecordRequest returns the sub-requests of a FcWriteFileRecord
+// request, or the reply, which is an echo of the request.
+func (p PDU) GetWriteFileRecordRequest() ([]FileRecord, error) {
+	data, err := p.getFileRecordData(FcWriteFileRecord)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, EcIllegalDataValue
+	}
+	var records []FileRecord
+	for len(data) > 0 {
+		if len(data) < fileRecordSubRequestSize {
+			return nil, EcIllegalDataValue
+		}
+		if data[0] != FileRecordReferenceType {
+			return nil, EcIllegalDataAddress
+		}
+		r := FileRecordRef{
+			File:   binary.BigEndian.Uint16(data[1:]),
+			Record: binary.BigEndian.Uint16(data[3:]),
+			Length: binary.BigEndian.Uint16(data[5:]),
+		}
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		end := fileRecordSubRequestSize + 2*int(r.Length)
+		if len(data) < end {
+			return nil, EcIllegalDataValue
+		}
+		values, err := DataToRegisters(data[fileRecordSubRequestSize:end])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, FileRecord{File: r.File, Record: r.Record, Values: values})
+		data = data[end:]
+	}
+	return records, nil
+}
+
+// handleFileRecordRequest answers FcReadFileRecord and FcWriteFileRecord requests.
+func handleFileRecordRequest(handler ProtocolHandler, p PDU) (PDU, error) {
+	fh, ok := handler.(FileRecordHandler)
+	if !ok {
+		return nil, ErrFcNotSupported
+	}
+	if p.GetFunctionCode() == FcWriteFileRecord {
+		records, err := p.GetWriteFileRecordRequest()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if err := fh.OnWriteFileRecord(r.File, r.Record, r.Values); err != nil {
+				debugf("handler.OnWriteFileRecord error:%v\n", err)
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+	refs, err := p.GetReadFileRecordRequest()
+	if err != nil {
+		return nil, err
+	}
+	reply := PDU{p[0], 0}
+	for _, r := range refs {
+		values, err := fh.OnReadFileRecord(r.File, r.Record, r.Length)
+		if err != nil {
+			debugf("handler.OnReadFileRecord error:%v\n", err)
+			return nil, err
+		}
+		if len(values) != int(r.Length) {
+			debugf("handler.OnReadFileRecord returned %v registers, expected %v\n", len(values), r.Length)
+			return nil, EcServerDeviceFailure
+		}
+		if len(reply)+2+2*len(values) > MaxPDUSize {
+			debugf("file record reply too long\n")
+			return nil, EcIllegalDataValue
+		}
+		data, _ := RegistersToData(values)
+		reply = append(reply, byte(1+len(data)), FileRecordReferenceType)
+		reply = append(reply, data...)
+	}
+	reply[1] = byte(len(reply) - 2)
+	return reply, nil
+}
+
+// ReadFileRecords reads the registers referenced by refs from a server, and
+// returns a FileRecord for each FileRecordRef. References are split and packed
+// into as few requests as possible to fit within MaxPDUSize.
+func ReadFileRecords(c RawTransactor, slaveID byte, refs []FileRecordRef) ([]FileRecord, error) {
+	records := make([]FileRecord, len(refs))
+	var batch []FileRecordRef
+	var batchIndex []int // index in records of each ref in batch
+	replySize := 2
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		req, err := MakeReadFileRecordRequest(batch)
+		if err != nil {
+			return err
+		}
+		rp, err := c.DoRawTransaction(slaveID, req)
+		if err != nil {
+			return err
+		}
+		values, err := rp.GetReadFileRecordReply()
+		if err != nil {
+			return err
+		}
+		if len(values) != len(batch) {
+			return fmt.Errorf("got %v file record sub-responses, expected %v", len(values), len(batch))
+		}
+		for i, v := range values {
+			if len(v) != int(batch[i].Length) {
+				return fmt.Errorf("got %v registers for %+v", len(v), batch[i])
+			}
+			records[batchIndex[i]].Values = append(records[batchIndex[i]].Values, v...)
+		}
+		batch, batchIndex, replySize = batch[:0], batchIndex[:0], 2
+		return nil
+	}
+	for i, r := range refs {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%+v: %w", r, err)
+		}
+		records[i] = FileRecord{File: r.File, Record: r.Record, Values: make([]uint16, 0, r.Length)}
+		for r.Length > 0 {
+			part := r
+			if part.Length > maxFileRecordRead {
+				part.Length = maxFileRecordRead
+			}
+			if replySize+2+2*int(part.Length) > MaxPDUSize ||
+				2+(len(batch)+1)*fileRecordSubRequestSize > MaxPDUSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			batch = append(batch, part)
+			batchIndex = append(batchIndex, i)
+			replySize += 2 + 2*int(part.Length)
+			r.Record += part.Length
+			r.Length -= part.Length
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// WriteFileRecords writes records to a server. Records are split and packed
+// into as few requests as possible to fit within MaxPDUSize.
+func WriteFileRecords(c RawTransactor, slaveID byte, records []FileRecord) error {
+	var batch []FileRecord
+	size := 2
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		req, err := MakeWriteFileRecordRequest(batch)
+		if err != nil {
+			return err
+		}
+		rp, err := c.DoRawTransaction(slaveID, req)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(req, rp) {
+			return fmt.Errorf("write file record reply %x does not echo the request", []byte(rp))
+		}
+		batch, size = batch[:0], 2
+		return nil
+	}
+	for _, r := range records {
+		if err := (FileRecordRef{r.File, r.Record, uint16(len(r.Values))}).validate(); err != nil || len(r.Values) > MaxFileRecordNumber+1 {
+			return fmt.Errorf("file %v record %v of %v registers can not be written", r.File, r.Record, len(r.Values))
+		}
+		for values := r.Values; len(values) > 0; {
+			part := FileRecord{File: r.File, Record: r.Record, Values: values}
+			if len(part.Values) > maxFileRecordWrite {
+				part.Values = part.Values[:maxFileRecordWrite]
+			}
+			if size+fileRecordSubRequestSize+2*len(part.Values) > MaxPDUSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, part)
+			size += fileRecordSubRequestSize + 2*len(part.Values)
+			r.Record += uint16(len(part.Values))
+			values = values[len(part.Values):]
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,110 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+// countingTransactor counts the raw transactions of a RawTransactor.
+type countingTransactor struct {
+	RawTransactor
+	count int
+}
+
+func (c *countingTransactor) DoRawTransaction(slaveID byte, req PDU) (PDU, error) {
+	c.count++
+	return c.RawTransactor.DoRawTransaction(slaveID, req)
+}
+
+func TestFileRecord(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, _ := connectMockRTU(t, slaveID)
+
+	var lock sync.Mutex
+	files := map[uint16][]uint16{}
+	file := func(n uint16) []uint16 {
+		f, ok := files[n]
+		if !ok {
+			f = make([]uint16, MaxFileRecordNumber+1)
+			files[n] = f
+		}
+		return f
+	}
+	file(4)[1], file(4)[2] = 0x0DFE, 0x0020
+	file(3)[9], file(3)[10] = 0x33CD, 0x0040
+
+	go client.Serve(&SimpleHandler{})
+	go server.Serve(&SimpleHandler{
+		ReadFileRecord: func(n, record, length uint16) ([]uint16, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]uint16(nil), file(n)[record:record+length]...), nil
+		},
+		WriteFileRecord: func(n, record uint16, values []uint16) error {
+			lock.Lock()
+			defer lock.Unlock()
+			copy(file(n)[record:], values)
+			return nil
+		},
+	})
+
+	t.Run("spec example", func(t *testing.T) {
+		req, err := MakeReadFileRecordRequest([]FileRecordRef{{4, 1, 2}, {3, 9, 2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := PDU{0x14, 0x0E, 0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02, 0x06, 0x00, 0x03, 0x00, 0x09, 0x00, 0x02}
+		if !bytes.Equal(req, want) {
+			t.Errorf("request %x, expected %x", req, want)
+		}
+		rp, err := client.DoRawTransaction(slaveID, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = PDU{0x14, 0x0C, 0x05, 0x06, 0x0D, 0xFE, 0x00, 0x20, 0x05, 0x06, 0x33, 0xCD, 0x00, 0x40}
+		if !bytes.Equal(rp, want) {
+			t.Errorf("reply %x, expected %x", rp, want)
+		}
+	})
+	t.Run("split write and read", func(t *testing.T) {
+		large := make([]uint16, 300)
+		for i := range large {
+			large[i] = uint16(i * 7)
+		}
+		records := []FileRecord{
+			{File: 7, Record: 100, Values: large},
+			{File: 8, Record: 0, Values: []uint16{1, 2, 3}},
+		}
+		ct := &countingTransactor{RawTransactor: client}
+		if err := WriteFileRecords(ct, slaveID, records); err != nil {
+			t.Fatal(err)
+		}
+		if ct.count != 3 {
+			t.Errorf("write took %v transactions, expected 3", ct.count)
+		}
+		ct.count = 0
+		got, err := ReadFileRecords(ct, slaveID, []FileRecordRef{{7, 100, 300}, {8, 0, 3}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("got %v, expected %v", got, records)
+		}
+		if ct.count != 3 {
+			t.Errorf("read took %v transactions, expected 3", ct.count)
+		}
+	})
+	t.Run("out of range", func(t *testing.T) {
+		req := PDU{0x14, 0x07, 0x06, 0x00, 0x04, 0x27, 0x0F, 0x00, 0x02}
+		if _, err := client.DoRawTransaction(slaveID, req); err == nil {
+			t.Fatal("expected exception")
+		}
+		if _, err := ReadFileRecords(client, slaveID, []FileRecordRef{{0, 0, 1}}); err == nil {
+			t.Fatal("expected error for file 0")
+		}
+	})
+}
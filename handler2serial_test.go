@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -64,6 +65,50 @@ func (s *mockSerial) MinDelay() time.Duration {
 func (s *mockSerial) BytesDelay(n int) time.Duration { return 0 }
 func (s *mockSerial) Stats() *Stats                  { return &s.s }
 
+// newMockSerialPair returns the connections of a client and a server that are
+// connected by pipes.
+func newMockSerialPair() (cc, sc *mockSerial) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	return newMockSerial("c", r2, w1, w1), newMockSerial("s", r1, w2, w2)
+}
+
+// connectMockRTU returns an RTUClient and an RTUServer of slaveID connected by
+// mock serial connections, which are closed when t ends. The client connection
+// is also returned, for its LastWritten.
+func connectMockRTU(t *testing.T, slaveID byte) (*RTUClient, *RTUServer, *mockSerial) {
+	cc, sc := newMockSerialPair()
+	client := NewRTUClient(cc, slaveID)
+	server := NewRTUServer(sc, slaveID)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server, cc
+}
+
+// mockBus is the side of the slaves of a mock serial connection, for tests that
+// fake the replies of slaves.
+type mockBus struct {
+	io.Reader             // reads the requests of the client
+	io.Writer             // writes replies to the client
+	com       *mockSerial // the client connection
+}
+
+// connectMockBus returns an RTUClient of slaveID connected to a mockBus, which
+// are closed when t ends.
+func connectMockBus(t *testing.T, slaveID byte) (*RTUClient, *mockBus) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	com := newMockSerial("c", r2, w1, w1)
+	client := NewRTUClient(com, slaveID)
+	t.Cleanup(func() {
+		w2.Close()
+		client.Close()
+	})
+	return client, &mockBus{Reader: r1, Writer: w2, com: com}
+}
+
 // TestHandler runs through each of simplymodbus.ca's samples, conforms both
 // end-to-end behavior and wire format.
 func TestHandler(t *testing.T) {
@@ -319,4 +364,97 @@ func TestHandler(t *testing.T) {
 		}
 		testTrans(header, request, response)
 	})
+
+	t.Run("Mask Write Register (FC=22)", func(t *testing.T) {
+		subtest = t
+		header, err := FcMaskWriteRegister.MakeRequestHeader(0x0004, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := RTU([]byte{0x11, 0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25, 0x66, 0xE2})
+		response := request
+		current := uint16(0x0012)
+		ch.ReadHoldingRegisterMasks = func(address uint16) (uint16, uint16, error) {
+			return 0x00F2, 0x0025, nil
+		}
+		sh.MaskWriteHoldingRegister = func(address, andMask, orMask uint16) error {
+			current = MaskRegister(current, andMask, orMask)
+			return nil
+		}
+		testTrans(header, request, response)
+		if current != 0x0017 {
+			t.Errorf("got register value %#04x, expected 0x0017", current)
+		}
+	})
+	t.Run("Read/Write Multiple Registers (FC=23)", func(t *testing.T) {
+		subtest = t
+		header, err := MakeReadWriteRequestHeader(0x0003, 0x0006, 0x000E, 0x0003)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := RTU([]byte{
+			0x11, 0x17, 0x00, 0x03, 0x00, 0x06, 0x00, 0x0E, 0x00, 0x03, 0x06,
+			0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x4B, 0x54,
+		})
+		response := RTU([]byte{
+			0x11, 0x17, 0x0C, 0x00, 0xFE, 0x0A, 0xCD, 0x00, 0x01, 0x00, 0x03,
+			0x00, 0x0D, 0x00, 0xFF, 0x0D, 0x75,
+		})
+		ws := []uint16{0x00FF, 0x00FF, 0x00FF}
+		rs := []uint16{0x00FE, 0x0ACD, 0x0001, 0x0003, 0x000D, 0x00FF}
+		var calls []string
+		sh.WriteHoldingRegisters = func(address uint16, values []uint16) error {
+			calls = append(calls, "write")
+			if address != 0x000E || !reflect.DeepEqual(values, ws) {
+				t.Errorf("server write got %v %v", address, values)
+			}
+			return nil
+		}
+		sh.ReadHoldingRegisters = func(address, quantity uint16) ([]uint16, error) {
+			calls = append(calls, "read")
+			if address != 0x0003 || quantity != 6 {
+				t.Errorf("server read got %v %v", address, quantity)
+			}
+			return rs, nil
+		}
+		ch.ReadHoldingRegisters = func(address, quantity uint16) ([]uint16, error) {
+			if address != 0x000E || quantity != 3 {
+				t.Errorf("client read got %v %v", address, quantity)
+			}
+			return ws, nil
+		}
+		ch.WriteHoldingRegisters = func(address uint16, values []uint16) error {
+			if address != 0x0003 || !reflect.DeepEqual(values, rs) {
+				t.Errorf("client write got %v %v", address, values)
+			}
+			return nil
+		}
+		testTrans(header, request, response)
+		if !reflect.DeepEqual(calls, []string{"write", "read"}) {
+			t.Errorf("server handler calls %v, expected write then read", calls)
+		}
+	})
+	t.Run("Read FIFO Queue (FC=24)", func(t *testing.T) {
+		subtest = t
+		header, err := FcReadFIFOQueue.MakeRequestHeader(0x04DE, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := RTU([]byte{0x11, 0x18, 0x04, 0xDE, 0x07, 0x87})
+		response := RTU([]byte{0x11, 0x18, 0x00, 0x06, 0x00, 0x02, 0x01, 0xB8, 0x12, 0x84, 0x18, 0x8D})
+		vs := []uint16{0x01B8, 0x1284}
+		sh.ReadFIFOQueue = func(pointerAddress uint16) ([]uint16, error) {
+			if pointerAddress != 0x04DE {
+				t.Errorf("server got pointer address %v", pointerAddress)
+			}
+			return vs, nil
+		}
+		ch.WriteFIFOQueue = func(pointerAddress uint16, values []uint16) error {
+			if pointerAddress != 0x04DE || !reflect.DeepEqual(values, vs) {
+				t.Errorf("client got %v %v", pointerAddress, values)
+			}
+			return nil
+		}
+		testTrans(header, request, response)
+	})
 }
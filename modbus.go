@@ -24,6 +24,10 @@ type ProtocolHandler interface {
 	// For write to server on server side, data is part of req.
 	// For read from server on client side, req is the req from client, and
 	// data is part of reply.
+	//
+	// FcReadWriteMultipleRegisters is handled as a FcWriteMultipleRegisters
+	// followed by a FcReadHoldingRegisters, so handlers see the equivalent
+	// requests of these function codes, with the write always called first.
 	OnWrite(req PDU, data []byte) error
 
 	// OnRead is called on the server for a read request,
@@ -45,22 +49,42 @@ type FunctionCode byte
 
 // Implemented FunctionCodes.
 const (
-	FcReadCoils              FunctionCode = 1
-	FcReadDiscreteInputs     FunctionCode = 2
-	FcReadHoldingRegisters   FunctionCode = 3
-	FcReadInputRegisters     FunctionCode = 4
-	FcWriteSingleCoil        FunctionCode = 5
-	FcWriteSingleRegister    FunctionCode = 6
-	FcWriteMultipleCoils     FunctionCode = 15
-	FcWriteMultipleRegisters FunctionCode = 16
-	// FcMaskWriteRegister          FunctionCode = 22
-	// FcReadWriteMultipleRegisters FunctionCode = 23
-	// FcReadFIFOQueue              FunctionCode = 24 // not supported for now.
+	FcReadCoils                  FunctionCode = 1
+	FcReadDiscreteInputs         FunctionCode = 2
+	FcReadHoldingRegisters       FunctionCode = 3
+	FcReadInputRegisters         FunctionCode = 4
+	FcWriteSingleCoil            FunctionCode = 5
+	FcWriteSingleRegister        FunctionCode = 6
+	FcReadExceptionStatus        FunctionCode = 7  // answered by a ServerInfo
+	FcDiagnostics                FunctionCode = 8  // serial line only, answered by RTUServer
+	FcGetCommEventCounter        FunctionCode = 11 // serial line only, answered by RTUServer
+	FcGetCommEventLog            FunctionCode = 12 // serial line only, answered by RTUServer
+	FcWriteMultipleCoils         FunctionCode = 15
+	FcWriteMultipleRegisters     FunctionCode = 16
+	FcReportServerID             FunctionCode = 17 // answered by a ServerInfo
+	FcReadFileRecord             FunctionCode = 20 // answered by a FileRecordHandler
+	FcWriteFileRecord            FunctionCode = 21 // answered by a FileRecordHandler
+	FcMaskWriteRegister          FunctionCode = 22
+	FcReadWriteMultipleRegisters FunctionCode = 23
+	FcReadFIFOQueue              FunctionCode = 24
+	// FcEncapsulatedInterface only supports MEI type 14 (MEIReadDeviceIdentification),
+	// which is answered by a DeviceIdentity on the server.
+	FcEncapsulatedInterface FunctionCode = 43
 )
 
 // Valid test if FunctionCode is a supported function, and not an error response.
+// Function codes registered by RegisterFunction are also valid.
 func (f FunctionCode) Valid() bool {
-	return (f > 0 && f < 7) || (f > 14 && f < 17) // || (f > 21 && f < 24)
+	if f.isStandard() {
+		return true
+	}
+	_, ok := customFunction(f)
+	return ok
+}
+
+// isStandard test if FunctionCode is implemented by this package.
+func (f FunctionCode) isStandard() bool {
+	return (f > 0 && f < 9) || f == 11 || f == 12 || (f > 14 && f < 18) || (f > 19 && f < 25) || f == 43
 }
 
 // MaxRange is the largest address in the Modbus protocol.
@@ -75,12 +99,16 @@ func (f FunctionCode) MaxPerPacket() uint16 {
 		return 2000
 	case FcReadHoldingRegisters, FcReadInputRegisters:
 		return 125 // 0x007D
-	case FcWriteSingleCoil, FcWriteSingleRegister:
+	case FcWriteSingleCoil, FcWriteSingleRegister, FcMaskWriteRegister:
 		return 1
 	case FcWriteMultipleCoils:
 		return 0x07B0 // 1968
 	case FcWriteMultipleRegisters:
 		return 0x007B
+	case FcReadWriteMultipleRegisters:
+		return 0x0079 // limited by write, read can be up to 0x007D
+	case FcReadFIFOQueue:
+		return 31
 	}
 	return 0 // unsupported functions
 }
@@ -112,7 +140,7 @@ func (f FunctionCode) MaxPerPacketSized(size int) uint16 {
 			return 1
 		}
 		return (s - 2) / 2
-	case FcWriteSingleCoil, FcWriteSingleRegister:
+	case FcWriteSingleCoil, FcWriteSingleRegister, FcMaskWriteRegister:
 		return 1
 	case FcWriteMultipleCoils:
 		if s < 8 {
@@ -128,6 +156,19 @@ func (f FunctionCode) MaxPerPacketSized(size int) uint16 {
 			return 1
 		}
 		return (s - 6) / 2
+	case FcReadWriteMultipleRegisters:
+		if s < 14 {
+			return 1
+		}
+		return (s - 10) / 2
+	case FcReadFIFOQueue:
+		if s < 7 {
+			return 1
+		}
+		if s > 67 {
+			return 31
+		}
+		return (s - 5) / 2
 	}
 	return 0 // unsupported functions
 }
@@ -136,6 +177,9 @@ func (f FunctionCode) MaxPerPacketSized(size int) uint16 {
 // client side StartTransaction.
 // The inverse functions are PDU.GetFunctionCode() .GetAddress() and .GetRequestCount().
 func (f FunctionCode) MakeRequestHeader(address, quantity uint16) (PDU, error) {
+	if f == FcReadWriteMultipleRegisters {
+		return nil, fmt.Errorf("%v needs both read and write ranges, use MakeReadWriteRequestHeader", f)
+	}
 	if quantity > f.MaxPerPacket() {
 		return nil, fmt.Errorf("%v can not pack %v at once", f, quantity)
 	}
@@ -143,7 +187,8 @@ func (f FunctionCode) MakeRequestHeader(address, quantity uint16) (PDU, error) {
 		return nil, fmt.Errorf("%v + %v out of range %v", address, quantity-1, f.MaxRange())
 	}
 	header := []byte{byte(f), byte(address >> 8), byte(address)}
-	if f.IsSingle() {
+	if f.IsSingle() || f == FcReadFIFOQueue {
+		// quantity of FcReadFIFOQueue is decided by the server
 		return PDU(header), nil
 	}
 	header = append(header, byte(quantity>>8), byte(quantity))
@@ -156,10 +201,37 @@ func (f FunctionCode) MakeRequestHeader(address, quantity uint16) (PDU, error) {
 	return PDU(header), nil
 }
 
+// MakeReadWriteRequestHeader makes a FcReadWriteMultipleRegisters PDU without any
+// write data, to be used for client side StartTransaction.
+// The server writes before it reads, so the ranges may overlap.
+func MakeReadWriteRequestHeader(readAddress, readQuantity, writeAddress, writeQuantity uint16) (PDU, error) {
+	f := FcReadWriteMultipleRegisters
+	if readQuantity == 0 || readQuantity > FcReadHoldingRegisters.MaxPerPacket() {
+		return nil, fmt.Errorf("%v can not read %v at once", f, readQuantity)
+	}
+	if writeQuantity == 0 || writeQuantity > f.MaxPerPacket() {
+		return nil, fmt.Errorf("%v can not write %v at once", f, writeQuantity)
+	}
+	if uint32(readAddress)+uint32(readQuantity) > uint32(f.MaxRange()) {
+		return nil, fmt.Errorf("%v + %v out of range %v", readAddress, readQuantity-1, f.MaxRange())
+	}
+	if uint32(writeAddress)+uint32(writeQuantity) > uint32(f.MaxRange()) {
+		return nil, fmt.Errorf("%v + %v out of range %v", writeAddress, writeQuantity-1, f.MaxRange())
+	}
+	return PDU([]byte{
+		byte(f),
+		byte(readAddress >> 8), byte(readAddress),
+		byte(readQuantity >> 8), byte(readQuantity),
+		byte(writeAddress >> 8), byte(writeAddress),
+		byte(writeQuantity >> 8), byte(writeQuantity),
+		byte(writeQuantity * 2),
+	}), nil
+}
+
 // IsUint16 returns true if the FunctionCode concerns 16bit values.
 func (f FunctionCode) IsUint16() bool {
 	switch f {
-	case 3, 4, 6, 16:
+	case 3, 4, 6, 16, 22, 23, 24:
 		return true
 	}
 	return false
@@ -177,7 +249,7 @@ func (f FunctionCode) IsBool() bool {
 // IsSingle returns true if the FunctionCode can transmit only one value.
 func (f FunctionCode) IsSingle() bool {
 	switch f {
-	case 5, 6:
+	case 5, 6, 22:
 		return true
 	}
 	return false
@@ -197,7 +269,7 @@ func (f FunctionCode) IsWriteToServer() bool {
 // FunctionCode 23 is both a read and write.
 func (f FunctionCode) IsReadToServer() bool {
 	switch f {
-	case 1, 2, 3, 4, 23:
+	case 1, 2, 3, 4, 23, 24:
 		return true
 	}
 	return false
@@ -284,15 +356,26 @@ func MatchPDU(ask PDU, ans PDU) bool {
 // Use ToExceptionCode to get the ExceptionCode for error.
 // Checks for errors 2 and 3 are done in GetRequestValues.
 func (p PDU) ValidateRequest() error {
-	if !p.GetFunctionCode().Valid() {
+	fc := p.GetFunctionCode()
+	if !fc.Valid() {
 		return EcIllegalFunction
 	}
-	if len(p) < 3 {
+	if _, custom := customFunction(fc); custom {
+		return nil // framed by its SizeFromHeader, there is nothing else to check
+	}
+	if len(p) < 3 && !fc.noRequestData() {
 		return EcIllegalDataAddress
 	}
 	return nil
 }
 
+// noRequestData returns true for function codes with requests of only the
+// function code.
+func (f FunctionCode) noRequestData() bool {
+	return f == FcReadExceptionStatus || f == FcGetCommEventCounter ||
+		f == FcGetCommEventLog || f == FcReportServerID
+}
+
 // GetFunctionCode returns the function code.
 func (p PDU) GetFunctionCode() FunctionCode {
 	if len(p) == 0 {
@@ -302,16 +385,22 @@ func (p PDU) GetFunctionCode() FunctionCode {
 }
 
 // GetAddress returns the starting address,
+// for FcReadWriteMultipleRegisters, this is the read starting address.
 // If PDU is invalid, behavior is undefined (can panic).
 func (p PDU) GetAddress() uint16 {
 	return uint16(p[1])<<8 | uint16(p[2])
 }
 
 // GetRequestCount returns the number of values requested,
+// for FcReadWriteMultipleRegisters, this is the number of values to read.
+// FcReadFIFOQueue requests do not have a count, 0 is returned.
 // If PDU is invalid (too short), return 0 with error.
 func (p PDU) GetRequestCount() (uint16, error) {
-	if p.GetFunctionCode().IsSingle() {
+	switch fc := p.GetFunctionCode(); {
+	case fc.IsSingle():
 		return 1, nil
+	case fc == FcReadFIFOQueue:
+		return 0, nil
 	}
 	if len(p) < 5 {
 		return 0, EcIllegalDataValue
@@ -325,6 +414,16 @@ func (p PDU) GetRequestValues() ([]byte, error) {
 	if f == 0 {
 		return nil, EcIllegalFunction
 	}
+	if f == FcMaskWriteRegister {
+		if len(p) != 7 {
+			debugf("fc %v got %v PDU bytes, expected 7", p.GetFunctionCode(), len(p))
+			return nil, EcIllegalDataValue
+		}
+		return p[3:], nil
+	}
+	if f == FcReadWriteMultipleRegisters {
+		return p.getReadWriteRequestValues()
+	}
 	if f.IsSingle() {
 		if len(p) != 5 {
 			debugf("fc %v got %v PDU bytes, expected 5", p.GetFunctionCode(), len(p))
@@ -367,8 +466,54 @@ func (p PDU) GetRequestValues() ([]byte, error) {
 	return p[6:], nil
 }
 
+func (p PDU) getReadWriteRequestValues() ([]byte, error) {
+	lb := len(p) - 10
+	if lb < 2 {
+		debugf("fc %v got %v PDU bytes, expected > 11", p.GetFunctionCode(), len(p))
+		return nil, EcIllegalDataValue
+	}
+	if lb != int(p[9]) && !OverSizeSupport {
+		debugf("declared %v bytes of data, but got %v bytes", p[9], lb)
+		return nil, EcIllegalDataValue
+	}
+	address := int(p[5])<<8 | int(p[6])
+	count := int(p[7])<<8 | int(p[8])
+	if address+count > int(p.GetFunctionCode().MaxRange()) {
+		debugf("write address out of range")
+		return nil, EcIllegalDataAddress
+	}
+	if lb != count*2 {
+		debugf("%v registers does not fit in %v bytes", count, lb)
+		return nil, EcIllegalDataValue
+	}
+	return p[10:], nil
+}
+
+// readPart returns the request that handlers see for the read part of p,
+// which is p itself except for FcReadWriteMultipleRegisters.
+func (p PDU) readPart() PDU {
+	if p.GetFunctionCode() != FcReadWriteMultipleRegisters {
+		return p
+	}
+	return PDU{byte(FcReadHoldingRegisters), p[1], p[2], p[3], p[4]}
+}
+
+// writePart returns the request that handlers see for the write part of p,
+// which is p itself except for FcReadWriteMultipleRegisters.
+func (p PDU) writePart() PDU {
+	if p.GetFunctionCode() != FcReadWriteMultipleRegisters {
+		return p
+	}
+	return append(PDU{byte(FcWriteMultipleRegisters), p[5], p[6], p[7], p[8], p[9]}, p[10:]...)
+}
+
 // GetReplyValues returns the values in a read reply.
+// For FcReadFIFOQueue, the values are the registers in the queue, without the byte
+// and FIFO counts.
 func (p PDU) GetReplyValues() ([]byte, error) {
+	if p.GetFunctionCode() == FcReadFIFOQueue {
+		return p.getFIFOReplyValues()
+	}
 	l := len(p) - 2 // bytes of values
 	if l < 1 || l != int(p[1]) {
 		return nil, fmt.Errorf("length mismatch with bytes")
@@ -376,8 +521,26 @@ func (p PDU) GetReplyValues() ([]byte, error) {
 	return p[2:], nil
 }
 
+func (p PDU) getFIFOReplyValues() ([]byte, error) {
+	if len(p) < 5 {
+		return nil, fmt.Errorf("FIFO reply too short")
+	}
+	bc := int(p[1])<<8 | int(p[2])
+	fifoCount := int(p[3])<<8 | int(p[4])
+	if bc != len(p)-3 || fifoCount*2 != len(p)-5 {
+		return nil, fmt.Errorf("length mismatch with bytes")
+	}
+	return p[5:], nil
+}
+
 // MakeReadReply produces the reply PDU based on the request PDU and read data.
 func (p PDU) MakeReadReply(data []byte) PDU {
+	if p.GetFunctionCode() == FcReadFIFOQueue {
+		bc := len(data) + 2
+		fifoCount := len(data) / 2
+		return PDU(append([]byte{byte(FcReadFIFOQueue), byte(bc >> 8), byte(bc),
+			byte(fifoCount >> 8), byte(fifoCount)}, data...))
+	}
 	return PDU(append([]byte{byte(p.GetFunctionCode()), byte(len(data))}, data...))
 }
 
@@ -386,10 +549,12 @@ func (p PDU) MakeReadReply(data []byte) PDU {
 func (p PDU) MakeWriteRequest(data []byte) PDU {
 	fc := p.GetFunctionCode()
 	switch fc {
-	case FcWriteSingleCoil, FcWriteSingleRegister:
+	case FcWriteSingleCoil, FcWriteSingleRegister, FcMaskWriteRegister:
 		return append(p[:3], data...)
 	case FcWriteMultipleCoils, FcWriteMultipleRegisters:
 		return append(p[:6], data...)
+	case FcReadWriteMultipleRegisters:
+		return append(p[:10], data...)
 	}
 	debugf("MakeRequestData unsupported for %v\n", fc)
 	return nil
@@ -397,6 +562,9 @@ func (p PDU) MakeWriteRequest(data []byte) PDU {
 
 // MakeWriteReply assumes the request is a successful write, and make the associated response.
 func (p PDU) MakeWriteReply() PDU {
+	if p.GetFunctionCode() == FcMaskWriteRegister {
+		return p // the reply is an echo of the request
+	}
 	if len(p) > 5 {
 		return p[:5] // works for 5,6,15,16
 	}
@@ -102,13 +102,73 @@ func (s *rtuPacketReader) Read(p []byte) (int, error) {
 // PDU header, if not enough info is in the header, then it returns the shortest possible.
 // isClient is true if a client/master is reading the packet.
 func GetPDUSizeFromHeader(header []byte, isClient bool) int {
-	if len(header) < 2 {
+	if len(header) < 1 {
 		return 2
 	}
 	ec, f := FunctionCode(header[0]).SeparateError()
 	if ec || !f.Valid() {
 		return 2
 	}
+	if cf, ok := customFunction(f); ok {
+		return cf.SizeFromHeader(header, isClient)
+	}
+	if !isClient && f.noRequestData() {
+		return 1
+	}
+	if len(header) < 2 || f == FcReadExceptionStatus {
+		// FcReadExceptionStatus: fc, status
+		return 2
+	}
+	if f == FcReadFileRecord || f == FcWriteFileRecord {
+		// fc, byte count, sub-requests or sub-responses
+		return 2 + int(header[1])
+	}
+	if f == FcGetCommEventCounter {
+		// fc, status, event count
+		return 5
+	}
+	if f == FcMaskWriteRegister {
+		// fc, address, and mask, or mask; the reply is an echo of the request
+		return 7
+	}
+	if f == FcDiagnostics {
+		// fc, sub-function, data; data of DiagReturnQueryData can be longer,
+		// which is not encoded in the header, so MakeDiagnosticsRequest only
+		// makes requests of 2 bytes of data.
+		return 5
+	}
+	if f == FcEncapsulatedInterface {
+		if !isClient {
+			// fc, MEI type, read device id code, object id
+			return 4
+		}
+		return getDeviceIDReplySize(header)
+	}
+	if f == FcReadFIFOQueue {
+		if !isClient {
+			// fc, FIFO pointer address
+			return 3
+		}
+		// fc, byte count (2 bytes), FIFO count and values
+		if len(header) < 3 {
+			return 3
+		}
+		return 3 + (int(header[1])<<8 | int(header[2]))
+	}
+	if f == FcReadWriteMultipleRegisters {
+		if isClient {
+			// fc, data bytes, data
+			return 2 + int(header[1])
+		}
+		// fc, read address, read count, write address, write count, data bytes, data
+		if len(header) < 10 {
+			return 10
+		}
+		if OverSizeSupport {
+			return 10 + (int(header[7])*256+int(header[8]))*2
+		}
+		return 10 + int(header[9])
+	}
 	if isClient == f.IsWriteToServer() {
 		// all packets without data: fc, address, and count
 		return 5
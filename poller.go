@@ -0,0 +1,260 @@
+package modbusone
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// PollGroup is a range of values to read from a slave periodically.
+type PollGroup struct {
+	SlaveID      byte
+	FunctionCode FunctionCode // one of FcReadCoils, FcReadDiscreteInputs, FcReadHoldingRegisters or FcReadInputRegisters
+	Address      uint16
+	Quantity     uint16 // split into as many requests as needed
+	Interval     time.Duration
+	Priority     int // groups of higher priority run first when due at the same time
+}
+
+// Overrun reports a group that could not be polled in time.
+type Overrun struct {
+	Group  PollGroup
+	Late   time.Duration // time since the group was due
+	Missed int           // number of polls skipped
+	Load   float64       // estimated bus load of all groups, see Poller.EstimatedLoad
+}
+
+// Poller polls groups of values using a client, one request at a time. The
+// results are handled by the handler of the client, or by OnReply.
+type Poller struct {
+	client RTUTransactionStarter
+
+	// Jitter is the part of the interval, from 0 to 1, used to randomize the
+	// first poll of each group, so groups of the same interval do not all run
+	// together.
+	Jitter float64
+	// BytesDelay, if not nil, estimates the time to transmit n bytes, such as
+	// SerialContext.BytesDelay, for EstimatedLoad.
+	BytesDelay func(n int) time.Duration
+	// BeforePoll, if not nil, is called before each poll of a group.
+	BeforePoll func(g PollGroup)
+	// OnPoll, if not nil, is called after each poll of a group, with the error of
+	// the first failed request.
+	OnPoll func(g PollGroup, err error)
+	// OnReply, if not nil while the client is a RawContextTransactor, is called
+	// with the reply to each request of a poll, instead of the handler of the
+	// client. An error fails the poll.
+	OnReply func(g PollGroup, req, reply PDU) error
+	// OnOverrun, if not nil, is called when a group is polled more than an
+	// interval late, or is not polled for more than an interval after it is due,
+	// such as when groups of higher priority take all the time.
+	OnOverrun func(o Overrun)
+
+	lock   sync.Mutex
+	groups []*pollGroup
+	added  chan struct{} // wakes Run to schedule added groups
+}
+
+// pollGroup is a PollGroup with its schedule.
+type pollGroup struct {
+	PollGroup
+	reqs []PDU
+	due  time.Time // zero before the first poll is scheduled
+}
+
+// NewPoller creates a Poller that uses client, which must be served by the caller.
+func NewPoller(client RTUTransactionStarter) *Poller {
+	return &Poller{
+		client: client,
+		Jitter: 1,
+		added:  make(chan struct{}, 1),
+	}
+}
+
+// Add adds a group to poll. It can be called while the poller is running.
+func (p *Poller) Add(g PollGroup) error {
+	switch g.FunctionCode {
+	case FcReadCoils, FcReadDiscreteInputs, FcReadHoldingRegisters, FcReadInputRegisters:
+	default:
+		return fmt.Errorf("%v can not be polled", g.FunctionCode)
+	}
+	if g.Interval <= 0 {
+		return fmt.Errorf("poll interval of %v is not positive", g.Interval)
+	}
+	if g.Quantity == 0 {
+		return fmt.Errorf("poll quantity is 0")
+	}
+	reqs, err := MakePDURequestHeaders(g.FunctionCode, g.Address, g.Quantity, nil)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	p.groups = append(p.groups, &pollGroup{PollGroup: g, reqs: reqs})
+	p.lock.Unlock()
+	select {
+	case p.added <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// EstimatedLoad returns the estimated part of the time the bus is used by the
+// polls, from the RTU sizes of requests and replies. A load above 1 can not be
+// kept up with. 0 is returned if BytesDelay is nil.
+func (p *Poller) EstimatedLoad() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.estimatedLoad()
+}
+
+func (p *Poller) estimatedLoad() float64 {
+	if p.BytesDelay == nil {
+		return 0
+	}
+	load := 0.0
+	for _, g := range p.groups {
+		load += float64(p.cost(g)) / float64(g.Interval)
+	}
+	return load
+}
+
+// cost returns the time needed to transmit the requests and replies of g.
+func (p *Poller) cost(g *pollGroup) time.Duration {
+	var d time.Duration
+	for _, req := range g.reqs {
+		n, _ := req.GetRequestCount()
+		reply := 2 + 2*int(n) // function code, byte count and registers
+		if g.FunctionCode.IsBool() {
+			reply = 2 + (int(n)+7)/8
+		}
+		d += p.BytesDelay(len(req) + 3 + reply + 3) // with slave id and crc
+	}
+	return d
+}
+
+// next returns the group to poll next, which is the due group of the highest
+// priority, or the group due the earliest if none is due. It also schedules the
+// first polls of new groups, and skips and reports the missed polls of groups
+// that are more than an interval past due.
+func (p *Poller) next(now time.Time) *pollGroup {
+	var overruns []Overrun
+	p.lock.Lock()
+	var next *pollGroup
+	for _, g := range p.groups {
+		if g.due.IsZero() {
+			g.due = now
+			if phase := int64(p.Jitter * float64(g.Interval)); phase > 0 {
+				g.due = now.Add(time.Duration(rand.Int63n(phase))) //nolint:gosec // Jitter doesn't need secure random numbers.
+			}
+		}
+		if late := now.Sub(g.due); late > g.Interval {
+			missed := int(late / g.Interval)
+			g.due = g.due.Add(time.Duration(missed) * g.Interval)
+			overruns = append(overruns, Overrun{Group: g.PollGroup, Late: late, Missed: missed})
+		}
+		if next == nil {
+			next = g
+			continue
+		}
+		gDue, nextDue := !g.due.After(now), !next.due.After(now)
+		switch {
+		case gDue && !nextDue:
+			next = g
+		case gDue && nextDue:
+			if g.Priority > next.Priority || (g.Priority == next.Priority && g.due.Before(next.due)) {
+				next = g
+			}
+		case !gDue && !nextDue:
+			if g.due.Before(next.due) {
+				next = g
+			}
+		}
+	}
+	load := p.estimatedLoad()
+	p.lock.Unlock()
+	if p.OnOverrun != nil {
+		for _, o := range overruns {
+			o.Load = load
+			p.OnOverrun(o)
+		}
+	}
+	return next
+}
+
+// Run polls the groups until ctx is done.
+func (p *Poller) Run(ctx context.Context) error {
+	for {
+		g := p.next(time.Now())
+		if g == nil || time.Until(g.due) > 0 {
+			var wait <-chan time.Time
+			var timer *time.Timer
+			if g != nil {
+				timer = time.NewTimer(time.Until(g.due))
+				wait = timer.C
+			}
+			select {
+			case <-wait:
+			case <-p.added:
+			case <-ctx.Done():
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		if p.BeforePoll != nil {
+			p.BeforePoll(g.PollGroup)
+		}
+		err := p.poll(ctx, g)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p.OnPoll != nil {
+			p.OnPoll(g.PollGroup, err)
+		}
+		p.reschedule(g, time.Now())
+	}
+}
+
+// poll runs the requests of g.
+func (p *Poller) poll(ctx context.Context, g *pollGroup) error {
+	raw, isRaw := p.client.(RawContextTransactor)
+	for _, req := range g.reqs {
+		if p.OnReply == nil || !isRaw {
+			if err := doTransactionContext(ctx, p.client, g.SlaveID, req); err != nil {
+				return err
+			}
+			continue
+		}
+		rp, err := raw.DoRawTransactionContext(ctx, g.SlaveID, req)
+		if err != nil {
+			return err
+		}
+		if err := p.OnReply(g.PollGroup, req, rp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reschedule sets the next due time of g, skipping and reporting missed polls.
+func (p *Poller) reschedule(g *pollGroup, now time.Time) {
+	p.lock.Lock()
+	due := g.due
+	g.due = due.Add(g.Interval)
+	missed := 0
+	if g.due.Before(now) {
+		missed = int(now.Sub(g.due)/g.Interval) + 1
+		g.due = g.due.Add(time.Duration(missed) * g.Interval)
+	}
+	load := p.estimatedLoad()
+	p.lock.Unlock()
+	if missed > 0 && p.OnOverrun != nil {
+		p.OnOverrun(Overrun{Group: g.PollGroup, Late: now.Sub(due), Missed: missed, Load: load})
+	}
+}
@@ -0,0 +1,142 @@
+package modbusone_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+// recordingStarter records the requests of transactions that take delay.
+type recordingStarter struct {
+	delay time.Duration
+	lock  sync.Mutex
+	reqs  []PDU
+	ids   []byte
+}
+
+func (s *recordingStarter) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
+	s.lock.Lock()
+	s.reqs = append(s.reqs, req)
+	s.ids = append(s.ids, slaveID)
+	s.lock.Unlock()
+	go func() {
+		time.Sleep(s.delay)
+		errChan <- nil
+	}()
+}
+
+func TestPoller(t *testing.T) {
+	t.Run("add", func(t *testing.T) {
+		p := NewPoller(&recordingStarter{})
+		if err := p.Add(PollGroup{SlaveID: 1, FunctionCode: FcWriteSingleCoil, Quantity: 1, Interval: time.Second}); err == nil {
+			t.Error("expected error for polling writes")
+		}
+		if err := p.Add(PollGroup{SlaveID: 1, FunctionCode: FcReadCoils, Quantity: 1}); err == nil {
+			t.Error("expected error for no interval")
+		}
+	})
+	t.Run("schedule", func(t *testing.T) {
+		s := &recordingStarter{}
+		p := NewPoller(s)
+		p.Jitter = 0
+		polls := make(map[byte]int)
+		var lock sync.Mutex
+		p.OnPoll = func(g PollGroup, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			lock.Lock()
+			polls[g.SlaveID]++
+			lock.Unlock()
+		}
+		groups := []PollGroup{
+			{SlaveID: 1, FunctionCode: FcReadHoldingRegisters, Quantity: 300, Interval: time.Second / 20},
+			{SlaveID: 2, FunctionCode: FcReadCoils, Quantity: 10, Interval: time.Second / 10, Priority: 1},
+		}
+		for _, g := range groups {
+			if err := p.Add(g); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/4+time.Second/40)
+		defer cancel()
+		p.Run(ctx)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if polls[1] < 4 || polls[1] > 6 || polls[2] < 2 || polls[2] > 3 {
+			t.Errorf("got polls %v, expected about 5 of slave 1 and 3 of slave 2", polls)
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.ids[0] != 2 {
+			t.Errorf("slave %v polled first, expected slave 2 of higher priority", s.ids[0])
+		}
+		if len(s.reqs) != polls[1]*3+polls[2] {
+			t.Errorf("got %v requests, expected 3 for each poll of 300 registers", len(s.reqs))
+		}
+	})
+	t.Run("overrun", func(t *testing.T) {
+		p := NewPoller(&recordingStarter{delay: time.Second / 30})
+		p.Jitter = 0
+		p.BytesDelay = func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+		overruns := make(chan Overrun, 100)
+		p.OnOverrun = func(o Overrun) { overruns <- o }
+		if err := p.Add(PollGroup{SlaveID: 1, FunctionCode: FcReadHoldingRegisters, Quantity: 10, Interval: time.Second / 100}); err != nil {
+			t.Fatal(err)
+		}
+		if l := p.EstimatedLoad(); l < 3.29 || l > 3.31 {
+			t.Errorf("got load %v, expected 3.3 for 33 bytes every 10ms", l)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+		defer cancel()
+		p.Run(ctx)
+		if len(overruns) == 0 {
+			t.Fatal("no overrun reported")
+		}
+		if o := <-overruns; o.Missed < 2 || o.Load < 3 {
+			t.Errorf("got %+v, expected at least 2 missed polls", o)
+		}
+	})
+	t.Run("starved", func(t *testing.T) {
+		s := &recordingStarter{delay: time.Second / 50}
+		p := NewPoller(s)
+		p.Jitter = 0
+		overruns := make(chan Overrun, 100)
+		p.OnOverrun = func(o Overrun) { overruns <- o }
+		// slaves 1 and 2 take turns, as each is due again while the other is polled
+		groups := []PollGroup{
+			{SlaveID: 1, FunctionCode: FcReadCoils, Quantity: 1, Interval: time.Second / 100, Priority: 1},
+			{SlaveID: 2, FunctionCode: FcReadCoils, Quantity: 1, Interval: time.Second / 100, Priority: 1},
+			{SlaveID: 3, FunctionCode: FcReadCoils, Quantity: 1, Interval: time.Second / 20},
+		}
+		for _, g := range groups {
+			if err := p.Add(g); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/4)
+		defer cancel()
+		p.Run(ctx)
+		s.lock.Lock()
+		ids := append([]byte(nil), s.ids...)
+		s.lock.Unlock()
+		for _, id := range ids {
+			if id == 3 {
+				t.Fatal("slave 3 was polled, expected it to be starved")
+			}
+		}
+		missed := 0
+		for len(overruns) > 0 {
+			if o := <-overruns; o.Group.SlaveID == 3 {
+				missed += o.Missed
+			}
+		}
+		if missed < 3 {
+			t.Errorf("got %v missed polls of slave 3, expected at least 3", missed)
+		}
+	})
+}
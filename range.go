@@ -0,0 +1,124 @@
+package modbusone
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RangeOptions sets how SyncClient splits ranges of values into requests to a
+// device.
+type RangeOptions struct {
+	// PDUSize limits the size of request and reply PDUs, for devices that do not
+	// support up to MaxPDUSize. 0 for no limit.
+	PDUSize int
+	// Concurrency is the max number of requests of a range to run at the same
+	// time, for clients that can pipeline requests, such as TCPClient with
+	// SetMaxOutstanding. 0 or 1 for one request at a time. It is ignored for
+	// other clients, such as RTUClient.
+	Concurrency int
+}
+
+// RangeError is returned by SyncClient range operations, with the sub-range
+// of the request that failed.
+type RangeError struct {
+	FunctionCode FunctionCode
+	Address      uint16 // first address of the failed request
+	Quantity     uint16 // quantity of the failed request
+	Err          error
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("%v of %v from address %v: %v", e.FunctionCode, e.Quantity, e.Address, e.Err)
+}
+
+// Unwrap returns the error of the failed request.
+func (e *RangeError) Unwrap() error {
+	return e.Err
+}
+
+// SetRangeOptions sets the RangeOptions for requests to slaveID.
+func (c *SyncClient) SetRangeOptions(slaveID byte, options RangeOptions) {
+	c.optionsLock.Lock()
+	defer c.optionsLock.Unlock()
+	if c.options == nil {
+		c.options = make(map[byte]RangeOptions)
+	}
+	c.options[slaveID] = options
+}
+
+func (c *SyncClient) rangeOptions(slaveID byte) RangeOptions {
+	c.optionsLock.Lock()
+	defer c.optionsLock.Unlock()
+	return c.options[slaveID]
+}
+
+// doRange splits count values from address into requests of fc as limited by the
+// RangeOptions of slaveID, and calls do for each, with the offset of the values
+// in the range. No more requests are started after a failure, but those running
+// are completed, so the *RangeError returned is of the failed request of the
+// lowest address.
+func (c *SyncClient) doRange(ctx context.Context, fc FunctionCode, slaveID byte, address uint16, count int,
+	do func(ctx context.Context, address uint16, offset, quantity int) error) error {
+	if count < 1 {
+		return fmt.Errorf("%v of %v values", fc, count)
+	}
+	if int(address)+count > int(fc.MaxRange()) {
+		return fmt.Errorf("%v + %v out of range %v", address, count-1, fc.MaxRange())
+	}
+	options := c.rangeOptions(slaveID)
+	per := int(fc.MaxPerPacket())
+	if options.PDUSize > 0 {
+		per = int(fc.MaxPerPacketSized(options.PDUSize))
+	}
+	slots := make(chan struct{}, 1)
+	if _, ok := c.client.(pipeliner); ok && options.Concurrency > 1 {
+		slots = make(chan struct{}, options.Concurrency)
+	}
+
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var failed *RangeError      // of the lowest address
+	stop := make(chan struct{}) // closed at the first failure
+LAUNCH:
+	for offset := 0; offset < count; offset += per {
+		quantity := per
+		if count-offset < per {
+			quantity = count - offset
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-stop:
+			break LAUNCH
+		case <-ctx.Done():
+			break LAUNCH
+		}
+		select {
+		case <-stop: // failed while waiting for the slot
+			break LAUNCH
+		default:
+		}
+		wg.Add(1)
+		go func(address uint16, offset, quantity int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := do(ctx, address, offset, quantity)
+			if err == nil {
+				return
+			}
+			errLock.Lock()
+			defer errLock.Unlock()
+			if failed == nil {
+				close(stop)
+			}
+			if failed == nil || address < failed.Address {
+				failed = &RangeError{FunctionCode: fc, Address: address, Quantity: uint16(quantity), Err: err}
+			}
+		}(address+uint16(offset), offset, quantity)
+	}
+	wg.Wait()
+	if failed != nil {
+		return failed
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,151 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestSyncClientRange(t *testing.T) {
+	const badAddress = 3000
+	var lock sync.Mutex
+	maxQuantity := 0
+	coils := make([]bool, 5000)
+	handler := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			lock.Lock()
+			if int(quantity) > maxQuantity {
+				maxQuantity = int(quantity)
+			}
+			lock.Unlock()
+			if address <= badAddress && badAddress < address+quantity {
+				return nil, EcServerDeviceFailure
+			}
+			values := make([]uint16, quantity)
+			for i := range values {
+				values[i] = address + uint16(i)
+			}
+			return values, nil
+		},
+		WriteCoils: func(address uint16, values []bool) error {
+			lock.Lock()
+			defer lock.Unlock()
+			copy(coils[address:], values)
+			return nil
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewTCPServer(listener)
+	defer server.Close()
+	go server.Serve(handler)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := NewTCPClient(conn, 1)
+	tc.SetMaxOutstanding(4)
+	c := NewSyncClient(tc)
+	defer c.Close()
+	go c.Serve()
+	c.SetRangeOptions(1, RangeOptions{PDUSize: 100, Concurrency: 4})
+	ctx := context.Background()
+
+	t.Run("read", func(t *testing.T) {
+		values, err := c.ReadHoldingRegisters(ctx, 1, 0, badAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range values {
+			if int(v) != i {
+				t.Fatalf("got %v at %v", v, i)
+			}
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if maxQuantity != 49 {
+			t.Errorf("got requests of up to %v registers, expected 49 for 100 bytes", maxQuantity)
+		}
+	})
+	t.Run("read error", func(t *testing.T) {
+		_, err := c.ReadHoldingRegisters(ctx, 1, 0, 5000)
+		var re *RangeError
+		if !errors.As(err, &re) {
+			t.Fatalf("got %v, expected range error", err)
+		}
+		if re.Address != 2989 || re.Quantity != 49 {
+			t.Errorf("got failed range of %v from %v, expected 49 from 2989", re.Quantity, re.Address)
+		}
+	})
+	t.Run("write", func(t *testing.T) {
+		values := make([]bool, 4000)
+		for i := range values {
+			values[i] = i%3 == 0
+		}
+		if err := c.WriteCoils(ctx, 1, 1000, values); err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for i, v := range values {
+			if coils[1000+i] != v {
+				t.Fatalf("got coil %v at %v", coils[1000+i], 1000+i)
+			}
+		}
+	})
+}
+
+// TestSyncClientRangeLowestError checks that the failed request of the lowest
+// address is returned, when requests fail out of order.
+func TestSyncClientRangeLowestError(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	tc := NewTCPClient(cc, 1)
+	tc.SetMaxOutstanding(4)
+	c := NewSyncClient(tc)
+	defer c.Close()
+	go c.Serve()
+	c.SetRangeOptions(1, RangeOptions{Concurrency: 4})
+	go func() {
+		var lock sync.Mutex
+		for {
+			b := make([]byte, 12)
+			if _, err := io.ReadFull(sc, b); err != nil {
+				return
+			}
+			go func() {
+				address := uint16(b[8])<<8 | uint16(b[9])
+				reply := []byte{b[0], b[1], 0, 0, 0, 3, 1, 0x83, byte(EcServerDeviceFailure)}
+				switch address {
+				case 125: // fails slower than 250
+					time.Sleep(time.Second / 20)
+				case 250:
+				default:
+					reply = append([]byte{b[0], b[1], 0, 0, 0, 3 + 250, 1, 3, 250}, make([]byte, 250)...)
+				}
+				lock.Lock()
+				defer lock.Unlock()
+				sc.Write(reply)
+			}()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		_, err := c.ReadHoldingRegisters(context.Background(), 1, 0, 500)
+		var re *RangeError
+		if !errors.As(err, &re) {
+			t.Fatalf("got %v, expected range error", err)
+		}
+		if re.Address != 125 {
+			t.Errorf("got failed range from %v, expected the lowest from 125", re.Address)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package modbusone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// AddressRange is a range of addresses from Start to End, inclusive.
+type AddressRange struct {
+	Start, End uint16
+}
+
+// Contains returns true if address is in the range.
+func (r AddressRange) Contains(address uint16) bool {
+	return r.Start <= address && address <= r.End
+}
+
+// ReadPlanner plans the reads of scattered addresses of a device, merging
+// nearby addresses into as few requests as possible.
+//
+// Forbidden ranges are never read. They are set by Forbid, and learned from
+// requests that fail with EcIllegalDataAddress. The zero value is ready to use,
+// with a GapTolerance of 0.
+type ReadPlanner struct {
+	// GapTolerance is the max number of unwanted addresses between two wanted
+	// addresses that are read by the same request.
+	GapTolerance uint16
+	// PDUSize limits the size of PDUs, as in RangeOptions. 0 for no limit.
+	PDUSize int
+
+	lock      sync.Mutex
+	forbidden map[FunctionCode][]AddressRange  // sorted and not overlapping, created on first use
+	breaks    map[FunctionCode]map[uint16]bool // addresses that must start a request, created on first use
+}
+
+// NewReadPlanner creates a ReadPlanner with gapTolerance.
+func NewReadPlanner(gapTolerance uint16) *ReadPlanner {
+	return &ReadPlanner{GapTolerance: gapTolerance}
+}
+
+// Forbid marks the addresses in r of fc as not to be read.
+func (p *ReadPlanner) Forbid(fc FunctionCode, r AddressRange) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.forbid(fc, r)
+}
+
+func (p *ReadPlanner) forbid(fc FunctionCode, r AddressRange) {
+	rs := append(p.forbidden[fc], r)
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Start < rs[j].Start })
+	merged := rs[:1]
+	for _, r := range rs[1:] {
+		last := &merged[len(merged)-1]
+		if uint32(r.Start) <= uint32(last.End)+1 {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	if p.forbidden == nil {
+		p.forbidden = make(map[FunctionCode][]AddressRange)
+	}
+	p.forbidden[fc] = merged
+}
+
+// Forbidden returns the forbidden ranges of fc.
+func (p *ReadPlanner) Forbidden(fc FunctionCode) []AddressRange {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]AddressRange(nil), p.forbidden[fc]...)
+}
+
+// isForbidden returns the forbidden range that contains address.
+func (p *ReadPlanner) isForbidden(fc FunctionCode, address uint16) (AddressRange, bool) {
+	rs := p.forbidden[fc]
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].End >= address })
+	if i < len(rs) && rs[i].Contains(address) {
+		return rs[i], true
+	}
+	return AddressRange{}, false
+}
+
+// Plan returns the read requests of fc for the wanted addresses, and the wanted
+// addresses that are forbidden.
+func (p *ReadPlanner) Plan(fc FunctionCode, addresses []uint16) (reqs []PDU, forbidden []uint16, err error) {
+	if !fc.IsReadToServer() || fc == FcReadWriteMultipleRegisters || fc == FcReadFIFOQueue {
+		return nil, nil, fmt.Errorf("%v can not be planned", fc)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	per := fc.MaxPerPacket()
+	if p.PDUSize > 0 {
+		per = fc.MaxPerPacketSized(p.PDUSize)
+	}
+	sorted := append([]uint16(nil), addresses...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var start, end uint16
+	open := false
+	closeRequest := func() error {
+		if !open {
+			return nil
+		}
+		open = false
+		req, err := fc.MakeRequestHeader(start, end-start+1)
+		reqs = append(reqs, req)
+		return err
+	}
+	for i, a := range sorted {
+		if i > 0 && a == sorted[i-1] {
+			continue
+		}
+		if _, ok := p.isForbidden(fc, a); ok {
+			forbidden = append(forbidden, a)
+			continue
+		}
+		if open && p.canExtend(fc, start, end, a, per) {
+			end = a
+			continue
+		}
+		if err := closeRequest(); err != nil {
+			return nil, nil, err
+		}
+		start, end, open = a, a, true
+	}
+	if err := closeRequest(); err != nil {
+		return nil, nil, err
+	}
+	return reqs, forbidden, nil
+}
+
+// canExtend returns true if the request from start to end can be extended to a.
+func (p *ReadPlanner) canExtend(fc FunctionCode, start, end, a uint16, per uint16) bool {
+	if a-end-1 > p.GapTolerance || uint32(a)-uint32(start)+1 > uint32(per) {
+		return false
+	}
+	for b := uint32(end) + 1; b <= uint32(a); b++ {
+		if p.breaks[fc][uint16(b)] {
+			return false
+		}
+		if _, ok := p.isForbidden(fc, uint16(b)); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Learn learns from req that failed with EcIllegalDataAddress, for the wanted
+// addresses. If req read unwanted addresses, the next plans read the runs of
+// wanted addresses in req without them, else req is split in halves. Only a
+// single address that failed alone is forbidden, as the unwanted addresses may
+// be legal.
+func (p *ReadPlanner) Learn(req PDU, addresses []uint16) {
+	fc := req.GetFunctionCode()
+	count, err := req.GetRequestCount()
+	if err != nil || count == 0 {
+		return
+	}
+	start := req.GetAddress()
+	end := start + count - 1
+	wanted := make(map[uint16]bool)
+	for _, a := range addresses {
+		wanted[a] = true
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if count == 1 {
+		p.forbid(fc, AddressRange{Start: start, End: start})
+		return
+	}
+	if p.breaks == nil {
+		p.breaks = make(map[FunctionCode]map[uint16]bool)
+	}
+	if p.breaks[fc] == nil {
+		p.breaks[fc] = make(map[uint16]bool)
+	}
+	gapped := false
+	for a := uint32(start) + 1; a <= uint32(end); a++ {
+		if wanted[uint16(a)] && !wanted[uint16(a-1)] {
+			p.breaks[fc][uint16(a)] = true // a run of wanted addresses after a gap
+			gapped = true
+		}
+	}
+	if !gapped {
+		p.breaks[fc][start+count/2] = true
+	}
+}
+
+// ReadRegisters reads the wanted addresses of holding or input registers from
+// slaveID by the plan of fc, re-planning after learning from requests that fail
+// with EcIllegalDataAddress. The values of unwanted addresses read along are
+// also returned. If some wanted addresses are forbidden, the values of the others
+// are returned with a *RangeError of the first forbidden one.
+func (p *ReadPlanner) ReadRegisters(ctx context.Context, c *SyncClient, slaveID byte, fc FunctionCode, addresses []uint16) (map[uint16]uint16, error) {
+	if !fc.IsUint16() {
+		return nil, fmt.Errorf("%v does not read registers", fc)
+	}
+	values := make(map[uint16]uint16)
+	err := p.read(ctx, c, slaveID, fc, addresses, func(start, count uint16, data []byte) error {
+		if len(data) != int(count)*2 {
+			return fmt.Errorf("got %v bytes, expected %v registers", len(data), count)
+		}
+		rs, err := DataToRegisters(data)
+		if err != nil {
+			return err
+		}
+		for i, v := range rs {
+			values[start+uint16(i)] = v
+		}
+		return nil
+	})
+	if err != nil && !errors.As(err, new(*RangeError)) {
+		return nil, err
+	}
+	return values, err
+}
+
+// ReadBools reads the wanted addresses of coils or discrete inputs, see
+// ReadRegisters.
+func (p *ReadPlanner) ReadBools(ctx context.Context, c *SyncClient, slaveID byte, fc FunctionCode, addresses []uint16) (map[uint16]bool, error) {
+	if !fc.IsBool() {
+		return nil, fmt.Errorf("%v does not read bools", fc)
+	}
+	values := make(map[uint16]bool)
+	err := p.read(ctx, c, slaveID, fc, addresses, func(start, count uint16, data []byte) error {
+		bs, err := DataToBools(data, count, fc)
+		if err != nil {
+			return err
+		}
+		for i, v := range bs {
+			values[start+uint16(i)] = v
+		}
+		return nil
+	})
+	if err != nil && !errors.As(err, new(*RangeError)) {
+		return nil, err
+	}
+	return values, err
+}
+
+// read runs the plan of the wanted addresses, and calls set with the data of
+// each reply.
+func (p *ReadPlanner) read(ctx context.Context, c *SyncClient, slaveID byte, fc FunctionCode, addresses []uint16,
+	set func(start, count uint16, data []byte) error) error {
+	done := make(map[string]bool) // requests that succeeded
+	for {
+		reqs, forbidden, err := p.Plan(fc, addresses)
+		if err != nil {
+			return err
+		}
+		replanned := false
+		for _, req := range reqs {
+			if done[string(req)] {
+				continue
+			}
+			data, err := c.transact(ctx, slaveID, req, nil)
+			if errors.Is(err, EcIllegalDataAddress) {
+				p.Learn(req, addresses)
+				replanned = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			count, _ := req.GetRequestCount()
+			if err := set(req.GetAddress(), count, data); err != nil {
+				return err
+			}
+			done[string(req)] = true
+		}
+		if replanned {
+			continue
+		}
+		if len(forbidden) > 0 {
+			return &RangeError{FunctionCode: fc, Address: forbidden[0], Quantity: 1, Err: EcIllegalDataAddress}
+		}
+		return nil
+	}
+}
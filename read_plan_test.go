@@ -0,0 +1,148 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestReadPlannerPlan(t *testing.T) {
+	header := func(address, quantity uint16) PDU {
+		t.Helper()
+		p, err := FcReadHoldingRegisters.MakeRequestHeader(address, quantity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	addresses := []uint16{300, 1, 2, 3, 10, 11, 50, 2}
+	p := NewReadPlanner(10)
+	reqs, forbidden, err := p.Plan(FcReadHoldingRegisters, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PDU{header(1, 11), header(50, 1), header(300, 1)}
+	if !reflect.DeepEqual(reqs, expected) || forbidden != nil {
+		t.Errorf("got %x %v, expected %x", reqs, forbidden, expected)
+	}
+
+	p.Forbid(FcReadHoldingRegisters, AddressRange{Start: 5, End: 6})
+	p.Forbid(FcReadHoldingRegisters, AddressRange{Start: 300, End: 310})
+	reqs, forbidden, err = p.Plan(FcReadHoldingRegisters, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []PDU{header(1, 3), header(10, 2), header(50, 1)}
+	if !reflect.DeepEqual(reqs, expected) || !reflect.DeepEqual(forbidden, []uint16{300}) {
+		t.Errorf("got %x %v, expected %x [300]", reqs, forbidden, expected)
+	}
+
+	p = NewReadPlanner(200)
+	p.PDUSize = 50 // 24 registers
+	reqs, _, err = p.Plan(FcReadHoldingRegisters, []uint16{0, 20, 23, 24, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []PDU{header(0, 24), header(24, 1), header(100, 1)}
+	if !reflect.DeepEqual(reqs, expected) {
+		t.Errorf("got %x, expected %x", reqs, expected)
+	}
+}
+
+func TestReadPlannerLearn(t *testing.T) {
+	var requests int64
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewTCPServer(listener)
+	defer server.Close()
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			atomic.AddInt64(&requests, 1)
+			if address <= 8 && 7 < address+quantity { // 7 and 8 are illegal
+				return nil, EcIllegalDataAddress
+			}
+			values := make([]uint16, quantity)
+			for i := range values {
+				values[i] = address + uint16(i)
+			}
+			return values, nil
+		},
+	})
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewSyncClient(NewTCPClient(conn, 1))
+	defer c.Close()
+	go c.Serve()
+	ctx := context.Background()
+
+	t.Run("gaps", func(t *testing.T) {
+		p := NewReadPlanner(5)
+		values, err := p.ReadRegisters(ctx, c, 1, FcReadHoldingRegisters, []uint16{1, 2, 6, 9, 12})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range []uint16{1, 2, 6, 9, 12} {
+			if values[a] != a {
+				t.Errorf("got %v at %v", values[a], a)
+			}
+		}
+		if got := p.Forbidden(FcReadHoldingRegisters); len(got) != 0 {
+			t.Errorf("learned %v, expected none of the unwanted addresses", got)
+		}
+	})
+	t.Run("legal gap", func(t *testing.T) {
+		p := NewReadPlanner(1)
+		values, err := p.ReadRegisters(ctx, c, 1, FcReadHoldingRegisters, []uint16{5, 7})
+		if !errors.Is(err, EcIllegalDataAddress) {
+			t.Fatalf("got %v, expected illegal address 7", err)
+		}
+		if !reflect.DeepEqual(values, map[uint16]uint16{5: 5}) {
+			t.Errorf("got %v", values)
+		}
+		expected := []AddressRange{{7, 7}}
+		if got := p.Forbidden(FcReadHoldingRegisters); !reflect.DeepEqual(got, expected) {
+			t.Errorf("learned %v, expected %v", got, expected)
+		}
+		// 6 was only in the gap of the failed request
+		values, err = p.ReadRegisters(ctx, c, 1, FcReadHoldingRegisters, []uint16{6})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values[6] != 6 {
+			t.Errorf("got %v", values)
+		}
+	})
+	t.Run("wanted", func(t *testing.T) {
+		atomic.StoreInt64(&requests, 0)
+		p := &ReadPlanner{} // not by NewReadPlanner
+		values, err := p.ReadRegisters(ctx, c, 1, FcReadHoldingRegisters, []uint16{6, 7, 8, 9})
+		var re *RangeError
+		if !errors.As(err, &re) || re.Address != 7 || !errors.Is(err, EcIllegalDataAddress) {
+			t.Fatalf("got %v, expected illegal address 7", err)
+		}
+		if !reflect.DeepEqual(values, map[uint16]uint16{6: 6, 9: 9}) {
+			t.Errorf("got %v", values)
+		}
+		expected := []AddressRange{{7, 8}}
+		if got := p.Forbidden(FcReadHoldingRegisters); !reflect.DeepEqual(got, expected) {
+			t.Errorf("learned %v, expected %v", got, expected)
+		}
+		// again without learning
+		atomic.StoreInt64(&requests, 0)
+		if _, err := p.ReadRegisters(ctx, c, 1, FcReadHoldingRegisters, []uint16{6, 7, 8, 9}); !errors.Is(err, EcIllegalDataAddress) {
+			t.Fatalf("got %v, expected illegal address", err)
+		}
+		if n := atomic.LoadInt64(&requests); n != 2 {
+			t.Errorf("got %v requests, expected 2", n)
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package modbusone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnState is the connection state of a ReconnectingTCPClient.
+type ConnState int
+
+// Connection states reported by ReconnectingTCPClient.OnStateChange.
+const (
+	Disconnected ConnState = iota
+	Connected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// NotConnectedError is returned by transactions of ReconnectingTCPClient while
+// there is no connection. Err is the last connection error, if any.
+type NotConnectedError struct {
+	Err error
+}
+
+func (e *NotConnectedError) Error() string {
+	if e.Err == nil {
+		return "not connected"
+	}
+	return "not connected: " + e.Err.Error()
+}
+
+// Unwrap returns the last connection error.
+func (e *NotConnectedError) Unwrap() error {
+	return e.Err
+}
+
+// TCPDialer returns a dial function of a TCP connection to address, to be used
+// by NewReconnectingTCPClient.
+func TCPDialer(address string) func(ctx context.Context) (io.ReadWriteCloser, error) {
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", address)
+	}
+}
+
+// ReconnectingTCPClient is a TCPClient that dials a new connection after the
+// connection is lost, with exponential backoff and jitter between attempts.
+//
+// Transactions fail fast with *NotConnectedError while there is no connection,
+// including transactions outstanding when the connection is lost. A connection
+// is also lost after MaxTimeouts consecutive transactions timed out, as a server
+// that is gone without closing it, such as by a power loss, never replies.
+type ReconnectingTCPClient struct {
+	ctx    context.Context //nolint:containedctx // ctx is internally created.
+	cancle context.CancelFunc
+	dial   func(ctx context.Context) (io.ReadWriteCloser, error)
+
+	SlaveID        byte
+	Timeout        time.Duration // time to wait for a reply, see TCPClient.Timeout
+	MaxOutstanding int           // see TCPClient.SetMaxOutstanding
+	Retry          RetryPolicy   // see TCPClient.Retry
+	MaxTimeouts    int           // consecutive time outs that lose the connection, 0 for never
+	MinBackoff     time.Duration // first wait after a failed connection, at least minReconnectBackoff
+	MaxBackoff     time.Duration // max wait between connection attempts
+
+	// OnStateChange, if not nil, is called when the connection state changes,
+	// with the error that caused a disconnection.
+	OnStateChange func(state ConnState, err error)
+
+	lock     sync.Mutex
+	client   *TCPClient // nil while disconnected
+	lastErr  error      // the last connection error
+	timeouts int        // consecutive time outs of client
+}
+
+// minReconnectBackoff is the min wait between connection attempts, so dialing
+// does not spin with a MinBackoff of 0.
+const minReconnectBackoff = time.Second / 10
+
+// ReconnectingTCPClient is also a Client, a RawTransactor and a RawContextTransactor.
+var (
+	_ Client               = &ReconnectingTCPClient{}
+	_ RawTransactor        = &ReconnectingTCPClient{}
+	_ RawContextTransactor = &ReconnectingTCPClient{}
+)
+
+// pipelines marks ReconnectingTCPClient as a pipeliner, as its TCPClient.
+func (c *ReconnectingTCPClient) pipelines() {}
+
+// NewReconnectingTCPClient creates a new client that uses dial to connect, such
+// as from TCPDialer, with the given slaveID as default. The first connection is
+// dialed by Serve.
+func NewReconnectingTCPClient(dial func(ctx context.Context) (io.ReadWriteCloser, error), slaveID byte) *ReconnectingTCPClient {
+	ctx, cancle := context.WithCancel(context.Background())
+	return &ReconnectingTCPClient{
+		ctx:            ctx,
+		cancle:         cancle,
+		dial:           dial,
+		SlaveID:        slaveID,
+		Timeout:        time.Second,
+		MaxOutstanding: 1,
+		MaxTimeouts:    3,
+		MinBackoff:     time.Second / 2,
+		MaxBackoff:     time.Second * 30,
+	}
+}
+
+// Serve connects and serves handler on each connection, until Close is called.
+func (c *ReconnectingTCPClient) Serve(handler ProtocolHandler) error {
+	defer c.Close()
+	backoff := c.MinBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if backoff < minReconnectBackoff {
+				backoff = minReconnectBackoff
+			}
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-c.ctx.Done():
+				return c.ctx.Err()
+			}
+			backoff *= 2
+			if backoff > c.MaxBackoff {
+				backoff = c.MaxBackoff
+			}
+		}
+		conn, err := c.dial(c.ctx)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return c.ctx.Err()
+			}
+			debugf("ReconnectingTCPClient dial error:%v\n", err)
+			c.setClient(nil, err)
+			continue
+		}
+		client := NewTCPClient(conn, c.SlaveID)
+		client.Timeout = c.Timeout
+		client.Retry = c.Retry
+		client.SetMaxOutstanding(c.MaxOutstanding)
+		c.lock.Lock()
+		if c.ctx.Err() != nil { // closed while dialing
+			c.lock.Unlock()
+			client.Close()
+			return c.ctx.Err()
+		}
+		c.client = client
+		c.timeouts = 0
+		c.lock.Unlock()
+		c.stateChange(Connected, nil)
+		start := time.Now()
+		err = client.Serve(handler)
+		c.setClient(nil, err)
+		c.stateChange(Disconnected, err)
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
+		if time.Since(start) > c.MaxBackoff {
+			backoff = c.MinBackoff // the connection was stable
+		}
+	}
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) //nolint:gosec // Jitter doesn't need secure random numbers.
+}
+
+func (c *ReconnectingTCPClient) setClient(client *TCPClient, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.client = client
+	if err != nil {
+		c.lastErr = err
+	}
+}
+
+func (c *ReconnectingTCPClient) stateChange(state ConnState, err error) {
+	if c.OnStateChange != nil {
+		c.OnStateChange(state, err)
+	}
+}
+
+// getClient returns the connected client, or a *NotConnectedError.
+func (c *ReconnectingTCPClient) getClient() (*TCPClient, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client == nil {
+		return nil, &NotConnectedError{Err: c.lastErr}
+	}
+	return c.client, nil
+}
+
+// Close stops reconnecting and closes the current connection.
+func (c *ReconnectingTCPClient) Close() error {
+	c.cancle()
+	c.lock.Lock()
+	client := c.client
+	c.lock.Unlock()
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+// DoTransaction starts a transaction, and returns a channel that returns an error
+// or nil, with the default slaveID.
+//
+// DoTransaction is blocking.
+//
+// For read from server, the PDU is sent as is (after been warped up in MBAP)
+// For write to server, the data part given will be ignored, and filled in by data from handler.
+func (c *ReconnectingTCPClient) DoTransaction(req PDU) error {
+	return c.DoTransaction2(c.SlaveID, req)
+}
+
+// DoTransaction2 is DoTransaction with a settable slaveID.
+func (c *ReconnectingTCPClient) DoTransaction2(slaveID byte, req PDU) error {
+	return c.DoTransactionContext(context.Background(), slaveID, req)
+}
+
+// DoTransactionContext is DoTransaction2 with a context, see TCPClient.DoTransactionContext.
+func (c *ReconnectingTCPClient) DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error {
+	_, err := c.doTransaction(ctx, slaveID, req, false)
+	return err
+}
+
+// DoRawTransaction sends req as is to slaveID, and returns the reply PDU, without
+// calling the handler. An exception reply is returned as an error.
+func (c *ReconnectingTCPClient) DoRawTransaction(slaveID byte, req PDU) (PDU, error) {
+	return c.DoRawTransactionContext(context.Background(), slaveID, req)
+}
+
+// DoRawTransactionContext is DoRawTransaction with a context, see TCPClient.DoTransactionContext.
+func (c *ReconnectingTCPClient) DoRawTransactionContext(ctx context.Context, slaveID byte, req PDU) (PDU, error) {
+	return c.doTransaction(ctx, slaveID, req, true)
+}
+
+// StartTransactionToServer starts a transaction, with a custom slaveID.
+// errChan is required, an error is set if the transaction failed, or
+// nil for success.
+//
+// StartTransactionToServer is not blocking.
+func (c *ReconnectingTCPClient) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
+	go func() {
+		errChan <- c.DoTransaction2(slaveID, req)
+	}()
+}
+
+func (c *ReconnectingTCPClient) doTransaction(ctx context.Context, slaveID byte, req PDU, raw bool) (PDU, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+	rp, err := client.doTransaction(ctx, slaveID, req, raw)
+	if err != nil && client.ctx.Err() != nil {
+		return nil, &NotConnectedError{Err: client.getExitError()}
+	}
+	if ctx.Err() == nil {
+		c.countTimeout(client, err)
+	}
+	return rp, err
+}
+
+// countTimeout counts consecutive time outs of client from the result of a
+// transaction, and closes client as lost after MaxTimeouts, so Serve reconnects.
+func (c *ReconnectingTCPClient) countTimeout(client *TCPClient, err error) {
+	c.lock.Lock()
+	if c.client != client {
+		c.lock.Unlock()
+		return
+	}
+	if !errors.As(err, new(*TimeoutError)) {
+		c.timeouts = 0
+		c.lock.Unlock()
+		return
+	}
+	c.timeouts++
+	n := c.timeouts
+	c.lock.Unlock()
+	if c.MaxTimeouts > 0 && n == c.MaxTimeouts {
+		debugf("ReconnectingTCPClient connection lost after %v time outs\n", n)
+		client.exit(fmt.Errorf("connection lost after %v consecutive time outs", n))
+		client.conn.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestReconnectingTCPClient(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	conns := make(chan net.Conn)
+	dials := 0 // only used by the Serve go routine
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dials++
+		if dials == 1 {
+			return nil, errRefused
+		}
+		cc, sc := net.Pipe()
+		select {
+		case conns <- sc:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return cc, nil
+	}
+	// serve replies to one request, then closes the connection.
+	serveOne := func(sc net.Conn) {
+		defer sc.Close()
+		b := make([]byte, 12)
+		if _, err := io.ReadFull(sc, b); err != nil {
+			return
+		}
+		sc.Write([]byte{b[0], b[1], 0, 0, 0, 5, b[6], 3, 2, 0, 0})
+	}
+
+	client := NewReconnectingTCPClient(dial, 1)
+	client.MinBackoff = time.Second / 100
+	client.MaxBackoff = time.Second / 20
+	states := make(chan ConnState, 10)
+	client.OnStateChange = func(state ConnState, err error) {
+		states <- state
+	}
+	defer client.Close()
+
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nce *NotConnectedError
+	if _, err := client.DoRawTransaction(1, req); !errors.As(err, &nce) {
+		t.Fatalf("got %v before Serve, expected not connected", err)
+	}
+	go client.Serve(&SimpleHandler{})
+
+	for i := 0; i < 2; i++ {
+		go serveOne(<-conns)
+		if s := <-states; s != Connected {
+			t.Fatalf("got state %v, expected %v", s, Connected)
+		}
+		if _, err := client.DoRawTransaction(1, req); err != nil {
+			t.Fatal(err)
+		}
+		if s := <-states; s != Disconnected {
+			t.Fatalf("got state %v, expected %v", s, Disconnected)
+		}
+		_, err := client.DoRawTransaction(1, req)
+		if !errors.As(err, &nce) {
+			t.Fatalf("got %v while disconnected, expected not connected", err)
+		}
+	}
+}
+
+func TestReconnectingTCPClientBackoffFloor(t *testing.T) {
+	var dials int64
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		atomic.AddInt64(&dials, 1)
+		return nil, errors.New("connection refused")
+	}
+	client := NewReconnectingTCPClient(dial, 1)
+	client.MinBackoff = 0
+	client.MaxBackoff = 0
+	go client.Serve(&SimpleHandler{})
+	time.Sleep(time.Second / 4)
+	client.Close()
+	if n := atomic.LoadInt64(&dials); n > 10 {
+		t.Errorf("dialed %v times in 1/4 second", n)
+	}
+}
+
+func TestReconnectingTCPClientTimeouts(t *testing.T) {
+	conns := make(chan net.Conn)
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		cc, sc := net.Pipe()
+		select {
+		case conns <- sc:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return cc, nil
+	}
+	client := NewReconnectingTCPClient(dial, 1)
+	client.Timeout = time.Second / 20
+	client.MaxTimeouts = 2
+	states := make(chan ConnState, 10)
+	client.OnStateChange = func(state ConnState, err error) {
+		states <- state
+	}
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+
+	// the first server reads requests and never replies, as if half open
+	sc := <-conns
+	defer sc.Close()
+	go io.Copy(ioutil.Discard, sc)
+	if s := <-states; s != Connected {
+		t.Fatalf("got state %v, expected %v", s, Connected)
+	}
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.DoRawTransaction(1, req); !errors.Is(err, ErrServerTimeOut) {
+			t.Fatalf("got %v, expected time out", err)
+		}
+	}
+	if s := <-states; s != Disconnected {
+		t.Fatalf("got state %v, expected %v", s, Disconnected)
+	}
+	sc2 := <-conns
+	defer sc2.Close()
+	if s := <-states; s != Connected {
+		t.Fatalf("got state %v, expected %v after time outs", s, Connected)
+	}
+}
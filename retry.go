@@ -0,0 +1,91 @@
+package modbusone
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy sets how clients retry transactions that failed by a time out, a
+// CRC error, or an EcServerDeviceBusy or EcAcknowledge exception. Other errors
+// are never retried. The zero RetryPolicy does not retry.
+//
+// Requests that write to the server, including FcReadWriteMultipleRegisters and
+// functions that are neither reads nor writes, are only retried if Idempotent
+// is true, since a write can take effect even if its reply is lost.
+//
+// Each retry is counted as Retries in Stats.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts, including the first one. No
+	// retries are made if it is less than 2.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for each further retry
+	// up to MaxBackoff. The wait is constant if MaxBackoff is not more than
+	// Backoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Idempotent marks writes as safe to retry.
+	Idempotent bool
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a copy of ctx that sets the RetryPolicy of the
+// transactions it is used for, instead of that of the client.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+// retryPolicy returns the RetryPolicy set on ctx, or def if none is.
+func retryPolicy(ctx context.Context, def RetryPolicy) RetryPolicy {
+	if p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return p
+	}
+	return def
+}
+
+// retryable returns true if req failing with err can be retried.
+func (p RetryPolicy) retryable(req PDU, err error) bool {
+	if err == nil {
+		return false
+	}
+	fc := req.GetFunctionCode()
+	if !p.Idempotent && (!fc.IsReadToServer() || fc.IsWriteToServer()) {
+		return false
+	}
+	return errors.Is(err, ErrServerTimeOut) || errors.Is(err, ErrorCrc) ||
+		errors.Is(err, EcServerDeviceBusy) || errors.Is(err, EcAcknowledge)
+}
+
+// do calls attempt for req until it succeeds, fails with an error that can not
+// be retried, or MaxAttempts is reached. The last error is returned, or the
+// error of ctx if it is done while waiting to retry. Retries are counted in
+// stats.
+func (p RetryPolicy) do(ctx context.Context, stats *Stats, req PDU, attempt func() error) error {
+	err := attempt()
+	wait := p.Backoff
+	for n := 1; n < p.MaxAttempts && p.retryable(req, err); n++ {
+		debugf("retry %v of %x after %v: %v\n", n, []byte(req), wait, err)
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		atomic.AddInt64(&stats.Retries, 1)
+		err = attempt()
+		if wait *= 2; wait > p.MaxBackoff {
+			wait = p.Backoff
+			if p.MaxBackoff > p.Backoff {
+				wait = p.MaxBackoff
+			}
+		}
+	}
+	return err
+}
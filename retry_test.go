@@ -0,0 +1,154 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestRTUClientRetry(t *testing.T) {
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := PDU{byte(FcWriteSingleRegister), 0, 0, 0, 1}
+	good := MakeRTU(1, PDU{3, 2, 0, 7})
+	busy := MakeRTU(1, PDU{0x83, byte(EcServerDeviceBusy)})
+	corrupted := MakeRTU(1, PDU{3, 2, 0, 7})
+	corrupted[len(corrupted)-1]++
+	idempotent := RetryPolicy{MaxAttempts: 3, Idempotent: true}
+
+	cases := []struct {
+		name     string
+		req      PDU
+		policy   *RetryPolicy // of the transaction, or nil for the client's
+		replies  [][]byte     // nil for no reply
+		attempts int
+		err      error
+	}{
+		{"crc then good", read, nil, [][]byte{corrupted, good}, 2, nil},
+		{"time out then good", read, nil, [][]byte{nil, good}, 2, nil},
+		{"busy", read, nil, [][]byte{busy, busy, busy}, 3, EcServerDeviceBusy},
+		{"illegal address", read, nil, [][]byte{MakeRTU(1, PDU{0x83, byte(EcIllegalDataAddress)})}, 1, EcIllegalDataAddress},
+		{"write", write, nil, [][]byte{nil}, 1, ErrServerTimeOut},
+		{"idempotent write", write, &idempotent, [][]byte{nil, MakeRTU(1, write)}, 2, nil},
+		{"no retry", read, &RetryPolicy{}, [][]byte{nil}, 1, ErrServerTimeOut},
+	}
+
+	client, bus := connectMockBus(t, 1)
+	client.SetServerProcessingTime(time.Second / 20)
+	client.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Second / 100}
+	go client.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error { return nil },
+	})
+	replies := make(chan []byte, 3)
+	var attempts int64
+	go func() {
+		b := make([]byte, MaxRTUSize)
+		for {
+			if _, err := bus.Read(b); err != nil {
+				return
+			}
+			atomic.AddInt64(&attempts, 1)
+			if reply := <-replies; reply != nil {
+				bus.Write(reply)
+			}
+		}
+	}()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt64(&attempts, 0)
+			bus.com.Stats().Reset()
+			for _, r := range tc.replies {
+				replies <- r
+			}
+			ctx := context.Background()
+			if tc.policy != nil {
+				ctx = WithRetryPolicy(ctx, *tc.policy)
+			}
+			_, err := client.DoRawTransactionContext(ctx, 1, tc.req)
+			if !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+				t.Errorf("got error %v, expected %v", err, tc.err)
+			}
+			if got := atomic.LoadInt64(&attempts); got != int64(tc.attempts) {
+				t.Errorf("got %v attempts, expected %v", got, tc.attempts)
+			}
+			if got := atomic.LoadInt64(&bus.com.Stats().Retries); got != int64(tc.attempts-1) {
+				t.Errorf("got %v retries in stats, expected %v", got, tc.attempts-1)
+			}
+		})
+	}
+	t.Run("started together", func(t *testing.T) {
+		bus.com.Stats().Reset()
+		for _, r := range [][]byte{corrupted, good, good} {
+			replies <- r
+		}
+		errChan := make(chan error)
+		client.StartTransactionToServer(1, read, errChan)
+		client.StartTransactionToServer(1, read, errChan)
+		for i := 0; i < 2; i++ {
+			if err := <-errChan; err != nil {
+				t.Error(err)
+			}
+		}
+		if got := atomic.LoadInt64(&bus.com.Stats().Retries); got != 1 {
+			t.Errorf("got %v retries in stats, expected 1", got)
+		}
+	})
+}
+
+func TestTCPClientRetry(t *testing.T) {
+	cc, sc := net.Pipe()
+	client := NewTCPClient(cc, 1)
+	client.Timeout = time.Second / 20
+	client.Retry = RetryPolicy{MaxAttempts: 5, Backoff: time.Second / 20}
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+	var attempts int64
+	go func() {
+		b := make([]byte, 12)
+		for {
+			if _, err := io.ReadFull(sc, b); err != nil {
+				return
+			}
+			if atomic.AddInt64(&attempts, 1) < 3 {
+				sc.Write([]byte{b[0], b[1], 0, 0, 0, 3, 1, 0x83, byte(EcAcknowledge)})
+				continue
+			}
+			sc.Write([]byte{b[0], b[1], 0, 0, 0, 5, 1, 3, 2, 0, 7})
+		}
+	}()
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	rp, err := client.DoRawTransaction(1, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second/10 {
+		t.Errorf("retried without backoff in %v", d)
+	}
+	if rp[3] != 7 {
+		t.Errorf("got reply %x", []byte(rp))
+	}
+	if got := atomic.LoadInt64(&client.Stats().Retries); got != 2 {
+		t.Errorf("got %v retries in stats, expected 2", got)
+	}
+
+	t.Run("canceled in backoff", func(t *testing.T) {
+		atomic.StoreInt64(&attempts, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Second/40, cancel)
+		if _, err := client.DoRawTransactionContext(ctx, 1, req); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, expected canceled", err)
+		}
+	})
+}
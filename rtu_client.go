@@ -1,6 +1,7 @@
 package modbusone
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -10,10 +11,15 @@ import (
 
 // RTUClient implements Client/Master side logic for RTU over a SerialContext to
 // be used by a ProtocolHandler.
+//
+// Transactions started while waiting for a reply, such as from other go
+// routines, are queued and run one at a time.
 type RTUClient struct {
 	com                  SerialContext
 	packetReader         PacketReader
 	SlaveID              byte
+	Retry                RetryPolicy     // retries of failed transactions, see WithRetryPolicy for one transaction
+	Breaker              *CircuitBreaker // if not nil, transactions to offline slaves fail fast
 	serverProcessingTime time.Duration
 	actions              chan rtuAction
 }
@@ -61,12 +67,82 @@ func (c *RTUClient) GetTransactionTimeOut(reqLen, ansLen int) time.Duration {
 
 type rtuAction struct {
 	t       clientActionType
+	ctx     context.Context //nolint:containedctx // ctx of a single action, nil for none.
 	data    RTU
 	err     error
 	errChan chan<- error
+	onReply func(PDU) // if set, the handler is bypassed and the reply is given here
+	probe   bool      // sent by probeOffline, even before the next probe is due
+}
+
+// done returns the done channel of the action's context, or nil if it has none.
+func (a rtuAction) done() <-chan struct{} {
+	if a.ctx == nil {
+		return nil
+	}
+	return a.ctx.Done()
+}
+
+// ctxErr returns the error of the action's context, or nil if it has none.
+func (a rtuAction) ctxErr() error {
+	if a.ctx == nil {
+		return nil
+	}
+	return a.ctx.Err()
+}
+
+// doActionContext queues act in actions and waits for its result. The action is
+// withdrawn if ctx is done before it is taken from actions.
+func doActionContext(ctx context.Context, actions chan<- rtuAction, act rtuAction) error {
+	errChan := make(chan error, 1) // buffered, so an abandoned action does not block Serve
+	act.ctx = ctx
+	act.errChan = errChan
+	select {
+	case actions <- act:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish sends the result of act, which was sent to a slave, to its errChan,
+// and reports it to Breaker.
+func (c *RTUClient) finish(act rtuAction, err error) {
+	if c.Breaker != nil {
+		c.Breaker.report(act.data[0], err)
+	}
+	act.errChan <- err
+}
+
+// probeOffline sends the Probe of b to the offline slaves every ProbeInterval,
+// until ctx is done.
+func (c *RTUClient) probeOffline(ctx context.Context, b *CircuitBreaker) {
+	if b.Probe == nil || b.ProbeInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(b.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, slaveID := range b.offline() {
+			err := doActionContext(ctx, c.actions, rtuAction{t: clientStart, data: MakeRTU(slaveID, b.Probe),
+				onReply: func(PDU) {}, probe: true})
+			debugf("RTUClient probe of slave %v: %v\n", slaveID, err)
+		}
+	}
 }
 
-// ErrServerTimeOut is the time out error for StartTransaction.
+// ErrServerTimeOut is the time out error for StartTransaction, wrapped by the
+// *TimeoutError returned by clients.
 var ErrServerTimeOut = errors.New("server timed out")
 
 type clientActionType int
@@ -92,6 +168,11 @@ func (a clientActionType) String() string {
 // Serve serves RTUClient handlers.
 func (c *RTUClient) Serve(handler ProtocolHandler) error {
 	defer c.Close()
+	if c.Breaker != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go c.probeOffline(ctx, c.Breaker)
+	}
 	go func() {
 		// Reader loop that always ready to received data. This make sure that read
 		// data is always new(ish), to dump data out that is received during an
@@ -111,8 +192,14 @@ func (c *RTUClient) Serve(handler ProtocolHandler) error {
 		}
 	}()
 
+	var queued []rtuAction // started while waiting for a reply
 	for {
-		act := <-c.actions
+		var act rtuAction
+		if len(queued) > 0 {
+			act, queued = queued[0], queued[1:]
+		} else {
+			act = <-c.actions
+		}
 		switch act.t {
 		default:
 			atomic.AddInt64(&c.com.Stats().OtherDrops, 1)
@@ -122,10 +209,20 @@ func (c *RTUClient) Serve(handler ProtocolHandler) error {
 			return act.err
 		case clientStart:
 		}
+		if err := act.ctxErr(); err != nil {
+			act.errChan <- err // abandoned before sending
+			continue
+		}
+		if c.Breaker != nil && act.data[0] != 0 {
+			if err := c.Breaker.allow(act.data[0], time.Now(), act.probe); err != nil {
+				act.errChan <- err // fail fast
+				continue
+			}
+		}
 		ap := act.data.fastGetPDU()
 		afc := ap.GetFunctionCode()
-		if afc.IsWriteToServer() {
-			data, err := handler.OnRead(ap)
+		if afc.IsWriteToServer() && act.onReply == nil {
+			data, err := handler.OnRead(ap.writePart())
 			if err != nil {
 				act.errChan <- err
 				continue
@@ -141,18 +238,29 @@ func (c *RTUClient) Serve(handler ProtocolHandler) error {
 		}
 		if act.data[0] == 0 {
 			time.Sleep(c.com.BytesDelay(len(act.data)))
+			if act.onReply != nil {
+				act.onReply(nil)
+			}
 			act.errChan <- nil // always success
 			continue           // do not wait for read on multicast
 		}
 
 		timeOutChan := time.After(c.GetTransactionTimeOut(len(act.data), MaxRTUSize))
+		var mismatch error // a reply from another slave, returned on time out
 
 	READ_LOOP:
 		for {
 		SELECT:
 			select {
 			case <-timeOutChan:
-				act.errChan <- ErrServerTimeOut
+				if mismatch != nil {
+					c.finish(act, mismatch)
+					break READ_LOOP
+				}
+				c.finish(act, &TimeoutError{SlaveID: act.data[0], FunctionCode: afc})
+				break READ_LOOP
+			case <-act.done():
+				act.errChan <- act.ctxErr()
 				break READ_LOOP
 			case react := <-c.actions:
 				switch react.t {
@@ -160,6 +268,9 @@ func (c *RTUClient) Serve(handler ProtocolHandler) error {
 					err := fmt.Errorf("unexpected action:%s", react.t)
 					act.errChan <- err
 					return err
+				case clientStart:
+					queued = append(queued, react) // run after this transaction
+					break SELECT
 				case clientError:
 					return react.err
 				case clientRead:
@@ -171,28 +282,37 @@ func (c *RTUClient) Serve(handler ProtocolHandler) error {
 				if react.data[0] != act.data[0] {
 					atomic.AddInt64(&c.com.Stats().IDDrops, 1)
 					debugf("RTUClient unexpected slaveId:%v in %v\n", act.data[0], hex.EncodeToString(react.data))
+					mismatch = &SlaveIDMismatchError{SlaveID: act.data[0], FunctionCode: afc, Got: react.data[0]}
 					break SELECT
 				}
 				rp, err := react.data.GetPDU()
 				if err != nil {
 					if errors.Is(err, ErrorCrc) {
 						atomic.AddInt64(&c.com.Stats().CrcErrors, 1)
+						err = &CRCError{SlaveID: act.data[0], FunctionCode: afc}
 					} else {
 						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
 					}
-					act.errChan <- err
+					c.finish(act, err)
 					break READ_LOOP
 				}
 				hasErr, fc := rp.GetFunctionCode().SeparateError()
 				if hasErr && fc == afc {
 					atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-					handler.OnError(ap, rp)
-					act.errChan <- fmt.Errorf("server reply with exception:%v", hex.EncodeToString(rp))
+					if act.onReply == nil {
+						handler.OnError(ap, rp)
+					}
+					c.finish(act, exceptionReplyError(act.data[0], rp))
 					break READ_LOOP
 				}
 				if !IsRequestReply(act.data.fastGetPDU(), rp) {
 					atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-					act.errChan <- fmt.Errorf("unexpected reply:%v", hex.EncodeToString(rp))
+					c.finish(act, &UnexpectedReplyError{SlaveID: act.data[0], Request: ap, Reply: rp})
+					break READ_LOOP
+				}
+				if act.onReply != nil {
+					act.onReply(rp)
+					c.finish(act, nil) // success
 					break READ_LOOP
 				}
 				if afc.IsReadToServer() {
@@ -200,17 +320,17 @@ func (c *RTUClient) Serve(handler ProtocolHandler) error {
 					bs, err := rp.GetReplyValues()
 					if err != nil {
 						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-						act.errChan <- err
+						c.finish(act, err)
 						break READ_LOOP
 					}
-					err = handler.OnWrite(ap, bs)
+					err = handler.OnWrite(ap.readPart(), bs)
 					if err != nil {
 						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
 					}
-					act.errChan <- err // success if nil
+					c.finish(act, err) // success if nil
 					break READ_LOOP
 				}
-				act.errChan <- nil // success
+				c.finish(act, nil) // success
 				break READ_LOOP
 			}
 		}
@@ -239,19 +359,104 @@ func (c *RTUClient) DoTransaction(req PDU) error {
 // errChan is required, an error is set is the transaction failed, or
 // nil for success.
 //
-// StartTransactionToServer is not blocking.
+// StartTransactionToServer is not blocking. It returns once Serve has taken the
+// transaction. Retries by Retry are queued after transactions started
+// meanwhile.
 //
 // For read from server, the PDU is sent as is (after been warped up in RTU)
 // For write to server, the data part given will be ignored, and filled in by data from handler.
 func (c *RTUClient) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
-	c.actions <- rtuAction{t: clientStart, data: MakeRTU(slaveID, req), errChan: errChan}
+	if c.Retry.MaxAttempts < 2 {
+		c.actions <- rtuAction{t: clientStart, data: MakeRTU(slaveID, req), errChan: errChan}
+		return
+	}
+	first := make(chan error, 1)
+	c.actions <- rtuAction{t: clientStart, data: MakeRTU(slaveID, req), errChan: first}
+	go func() {
+		ctx := context.Background()
+		errChan <- c.Retry.do(ctx, c.com.Stats(), req, func() error {
+			if first != nil {
+				err := <-first
+				first = nil
+				return err
+			}
+			return doActionContext(ctx, c.actions, rtuAction{t: clientStart, data: MakeRTU(slaveID, req)})
+		})
+	}()
 }
 
+// DoRawTransaction sends req as is to slaveID, and returns the reply PDU, without
+// calling the handler. An exception reply is returned as an error.
+// For multicast (slaveID 0), a nil PDU is returned on success.
+//
+// DoRawTransaction is blocking.
+func (c *RTUClient) DoRawTransaction(slaveID byte, req PDU) (PDU, error) {
+	return c.DoRawTransactionContext(context.Background(), slaveID, req)
+}
+
+// DoTransactionContext is DoTransaction with a settable slaveID and a context.
+// The transaction is withdrawn if ctx is done before it is sent, and the wait
+// for a reply ends when ctx is done.
+func (c *RTUClient) DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error {
+	return retryPolicy(ctx, c.Retry).do(ctx, c.com.Stats(), req, func() error {
+		return doActionContext(ctx, c.actions, rtuAction{t: clientStart, data: MakeRTU(slaveID, req)})
+	})
+}
+
+// DoRawTransactionContext is DoRawTransaction with a context, see DoTransactionContext.
+func (c *RTUClient) DoRawTransactionContext(ctx context.Context, slaveID byte, req PDU) (PDU, error) {
+	var reply PDU
+	err := retryPolicy(ctx, c.Retry).do(ctx, c.com.Stats(), req, func() error {
+		return doActionContext(ctx, c.actions, rtuAction{t: clientStart, data: MakeRTU(slaveID, req),
+			onReply: func(rp PDU) { reply = append(PDU(nil), rp...) }})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// RawTransactor is an interface implemented by clients that can send PDUs as is,
+// and return the reply PDUs, bypassing the ProtocolHandler.
+type RawTransactor interface {
+	DoRawTransaction(slaveID byte, req PDU) (PDU, error)
+}
+
+// Asserts that RTUClient implements RawTransactor.
+var _ RawTransactor = &RTUClient{}
+
+// RawContextTransactor is an interface implemented by clients that can send PDUs
+// as is, with a context.Context to abandon the transaction.
+type RawContextTransactor interface {
+	DoRawTransactionContext(ctx context.Context, slaveID byte, req PDU) (PDU, error)
+}
+
+// Asserts that RTUClient implements RawContextTransactor.
+var _ RawContextTransactor = &RTUClient{}
+
 // RTUTransactionStarter is an interface implemented by RTUClient.
 type RTUTransactionStarter interface {
 	StartTransactionToServer(slaveID byte, req PDU, errChan chan error)
 }
 
+// doTransactionContext runs the transaction of req on c, using
+// DoTransactionContext if c has it, else the wait ends when ctx is done.
+func doTransactionContext(ctx context.Context, c RTUTransactionStarter, slaveID byte, req PDU) error {
+	if cc, ok := c.(interface {
+		DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error
+	}); ok {
+		return cc.DoTransactionContext(ctx, slaveID, req)
+	}
+	errChan := make(chan error, 1)
+	c.StartTransactionToServer(slaveID, req, errChan)
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // DoTransactions runs the reqs transactions in order.
 // If any error is encountered, it returns early and reports the index number and
 // error message.
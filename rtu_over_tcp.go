@@ -0,0 +1,104 @@
+package modbusone
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiegeo/modbusone/crc"
+)
+
+// rtuOverTCP is a SerialContext and PacketReader of RTU packets tunneled over a
+// stream connection, such as by serial to Ethernet converters. Packets are
+// framed by their headers and CRC, instead of timing.
+type rtuOverTCP struct {
+	s        Stats // first for alignment
+	conn     io.ReadWriteCloser
+	r        *bufio.Reader
+	isClient bool
+}
+
+var (
+	_ SerialContext = &rtuOverTCP{}
+	_ PacketReader  = &rtuOverTCP{}
+)
+
+// NewRTUOverTCPContext creates a SerialContext for RTU packets over a TCP connection
+// (or any stream), without MBAP headers. isClient is true if the client/master side
+// is reading the packets. There are no delays between packets.
+func NewRTUOverTCPContext(conn io.ReadWriteCloser, isClient bool) SerialContext {
+	size := MaxRTUSize
+	if OverSizeSupport && OverSizeMaxRTU > size {
+		size = OverSizeMaxRTU
+	}
+	return &rtuOverTCP{conn: conn, r: bufio.NewReaderSize(conn, size), isClient: isClient}
+}
+
+// NewRTUOverTCPClient creates a new client communicating with RTU packets over
+// a TCP connection with the given slaveID as default.
+func NewRTUOverTCPClient(conn io.ReadWriteCloser, slaveID byte) *RTUClient {
+	return NewRTUClient(NewRTUOverTCPContext(conn, true), slaveID)
+}
+
+// NewRTUOverTCPServer creates a server of RTU packets over a TCP connection
+// listening on slaveID.
+func NewRTUOverTCPServer(conn io.ReadWriteCloser, slaveID byte) *RTUServer {
+	return NewRTUServer(NewRTUOverTCPContext(conn, false), slaveID)
+}
+
+func (s *rtuOverTCP) PacketReaderFace() {}
+
+// Read reads a RTU packet, as framed by GetRTUSizeFromHeader. If the CRC of the
+// packet is not valid, the stream is considered out of sync, and all buffered
+// data is dropped after returning the packet.
+func (s *rtuOverTCP) Read(p []byte) (int, error) {
+	atomic.AddInt64(&s.s.ReadPackets, 1)
+	expected := smallestRTUSize
+	for {
+		if expected > s.r.Size() {
+			atomic.AddInt64(&s.s.OtherDrops, 1)
+			debugf("RTUOverTCP packet size %v too large, drop buffered data", expected)
+			s.r.Discard(s.r.Buffered())
+			expected = smallestRTUSize
+		}
+		h, err := s.r.Peek(expected)
+		if err != nil {
+			return 0, err
+		}
+		size := GetRTUSizeFromHeader(h, s.isClient)
+		if size > expected {
+			expected = size
+			continue
+		}
+		n := copy(p, h[:size])
+		s.r.Discard(size)
+		if !crc.Validate(p[:n]) {
+			s.r.Discard(s.r.Buffered())
+		}
+		return n, nil
+	}
+}
+
+func (s *rtuOverTCP) Write(b []byte) (int, error) {
+	debugf("RTUOverTCP Write:%x\n", b)
+	return s.conn.Write(b)
+}
+
+func (s *rtuOverTCP) Close() error {
+	return s.conn.Close()
+}
+
+// MinDelay returns 0, as packets are not delimited by silent intervals.
+func (s *rtuOverTCP) MinDelay() time.Duration {
+	return 0
+}
+
+// BytesDelay returns 0, as there is no baud rate to wait for.
+func (s *rtuOverTCP) BytesDelay(n int) time.Duration {
+	return 0
+}
+
+func (s *rtuOverTCP) Stats() *Stats {
+	return &s.s
+}
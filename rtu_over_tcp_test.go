@@ -0,0 +1,88 @@
+package modbusone_test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestRTUOverTCP(t *testing.T) {
+	slaveID := byte(0x11)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewRTUOverTCPClient(conn, slaveID)
+	client.SetServerProcessingTime(time.Second / 5)
+	defer client.Close()
+	sc := NewRTUOverTCPContext(sconn, false)
+	server := NewRTUServer(sc, slaveID)
+	defer server.Close()
+
+	var got []uint16
+	go client.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			got = values
+			return nil
+		},
+	})
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return []uint16{0xAE41, 0x5652, 0x4340}[:quantity], nil
+		},
+	})
+
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0x6B, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("read holding registers", func(t *testing.T) {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			if err := client.DoTransaction(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("100 transactions took %v, traffic is throttled", d)
+		}
+		if len(got) != 3 || got[0] != 0xAE41 || got[2] != 0x4340 {
+			t.Errorf("got %x", got)
+		}
+	})
+	t.Run("crc error and other id", func(t *testing.T) {
+		bad := []byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x76, 0x88}
+		other := []byte{0x12, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x76, 0xB4}
+		if _, err := conn.Write(bad); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second / 20)
+		if _, err := conn.Write(other); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DoTransaction(req); err != nil {
+			t.Fatal(err)
+		}
+		stats := sc.Stats()
+		if n := atomic.LoadInt64(&stats.CrcErrors); n != 1 {
+			t.Errorf("got %v CRC errors, expected 1", n)
+		}
+		if n := atomic.LoadInt64(&stats.IDDrops); n != 1 {
+			t.Errorf("got %v ID drops, expected 1", n)
+		}
+	})
+}
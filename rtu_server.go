@@ -14,9 +14,16 @@ import (
 // RTUServer implements Server/Slave side logic for RTU over a SerialContext to
 // be used by a ProtocolHandler.
 type RTUServer struct {
+	diag         diagnostics // first for alignment
+	events       commEventLog
 	com          SerialContext
 	packetReader PacketReader
 	SlaveID      byte
+
+	// DeviceIdentity answers Read Device Identification requests, if not nil.
+	DeviceIdentity *DeviceIdentity
+	// ServerInfo answers Read Exception Status and Report Server ID requests, if not nil.
+	ServerInfo *ServerInfo
 }
 
 // NewRTUServer creates a RTU server on SerialContext listening on slaveID.
@@ -50,15 +57,25 @@ func (s *RTUServer) Serve(handler ProtocolHandler) error {
 	var p PDU
 
 	var ioErr error // make continue do io error checking
-	wp := func(pdu PDU, slaveId byte) {
-		if slaveId == 0 {
+	wp := func(pdu PDU, slaveId byte, event CommEvent) {
+		if slaveId == 0 || pdu == nil {
+			atomic.AddInt64(&s.diag.noResponses, 1)
 			return
 		}
 		time.Sleep(delay)
 		_, ioErr = s.com.Write(MakeRTU(slaveId, pdu))
+		var timeout interface{ Timeout() bool }
+		if errors.As(ioErr, &timeout) && timeout.Timeout() {
+			event |= CommEventSendWriteTimeout
+		}
+		s.events.add(event)
 	}
 	wec := func(err error, slaveId byte) {
-		wp(ExceptionReplyPacket(p, ToExceptionCode(err)), slaveId)
+		ec := ToExceptionCode(err)
+		if slaveId != 0 {
+			s.diag.countException(ec)
+		}
+		wp(ExceptionReplyPacket(p, ec), slaveId, CommEventSend|commEventSendFlag(ec))
 	}
 
 	for ioErr == nil {
@@ -78,6 +95,11 @@ func (s *RTUServer) Serve(handler ProtocolHandler) error {
 			} else {
 				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
 			}
+			event := CommEventReceive | CommEventReceiveCommError
+			if n == len(rb) {
+				event |= CommEventReceiveOverrun
+			}
+			s.events.add(event)
 			debugf("RTUServer drop read packet:%v\n", err)
 			continue
 		}
@@ -86,6 +108,20 @@ func (s *RTUServer) Serve(handler ProtocolHandler) error {
 			debugf("RTUServer drop packet to other id:%v\n", r[0])
 			continue
 		}
+		atomic.AddInt64(&s.diag.serverMessages, 1)
+		receive := CommEventReceive
+		if r[0] == 0 {
+			receive |= CommEventReceiveBroadcast
+		}
+		if s.diag.isListenOnly() {
+			receive |= CommEventReceiveListenOnly
+		}
+		s.events.add(receive)
+		if s.diag.isListenOnly() && !p.isRestartCommunications() {
+			atomic.AddInt64(&s.diag.noResponses, 1)
+			debugf("RTUServer in listen only mode\n")
+			continue
+		}
 		err = p.ValidateRequest()
 		if err != nil {
 			atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
@@ -93,37 +129,35 @@ func (s *RTUServer) Serve(handler ProtocolHandler) error {
 			wec(err, r[0])
 			continue
 		}
-		fc := p.GetFunctionCode()
-		if fc.IsReadToServer() {
-			data, err := handler.OnRead(p)
-			if err != nil {
-				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-				debugf("RTUServer handler.OnOutput error:%v\n", err)
-				wec(err, r[0])
-				continue
-			}
-			wp(p.MakeReadReply(data), r[0])
-		} else if fc.IsWriteToServer() {
-			data, err := p.GetRequestValues()
-			if err != nil {
-				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-				debugf("RTUServer p.GetRequestValues error:%v\n", err)
-				wec(err, r[0])
-				continue
-			}
-			err = handler.OnWrite(p, data)
-			if err != nil {
-				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-				debugf("RTUServer handler.OnInput error:%v\n", err)
-				wec(err, r[0])
-				continue
-			}
-			wp(p.MakeWriteReply(), r[0])
+		reply, err := s.handleRequest(handler, p)
+		if err != nil {
+			atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
+			debugf("RTUServer handleRequest error:%v\n", err)
+			wec(err, r[0])
+			continue
+		}
+		if fc := p.GetFunctionCode(); fc != FcGetCommEventCounter && fc != FcGetCommEventLog {
+			s.events.count()
 		}
+		wp(reply, r[0], CommEventSend)
 	}
 	return ioErr
 }
 
+func (s *RTUServer) handleRequest(handler ProtocolHandler, p PDU) (PDU, error) {
+	switch p.GetFunctionCode() {
+	case FcEncapsulatedInterface:
+		return s.DeviceIdentity.handleRequest(p)
+	case FcReadExceptionStatus, FcReportServerID:
+		return s.ServerInfo.handleRequest(p)
+	case FcDiagnostics:
+		return s.handleDiagnostics(p)
+	case FcGetCommEventCounter, FcGetCommEventLog:
+		return s.handleCommEvents(p)
+	}
+	return handleRequest(handler, p)
+}
+
 // Close closes the server and closes the connect.
 func (s *RTUServer) Close() error {
 	return s.com.Close()
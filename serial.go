@@ -55,6 +55,7 @@ type Stats struct {
 	FormateWarnings  int64
 	IDDrops          int64
 	OtherDrops       int64
+	Retries          int64 // transactions retried by a RetryPolicy, not a drop
 }
 
 // Reset the stats to zero.
@@ -67,6 +68,7 @@ func (s *Stats) Reset() {
 	atomic.StoreInt64(&s.FormateWarnings, 0)
 	atomic.StoreInt64(&s.IDDrops, 0)
 	atomic.StoreInt64(&s.OtherDrops, 0)
+	atomic.StoreInt64(&s.Retries, 0)
 }
 
 // TotalDrops adds up all the errors for the total number of read packets dropped.
@@ -0,0 +1,44 @@
+package modbusone
+
+// handleRequest calls handler for a validated request PDU p, and returns the reply PDU.
+// If an error is returned, it should be sent back as an exception reply.
+//
+// For a request that both writes and reads (FcReadWriteMultipleRegisters),
+// the write is always handled before the read.
+func handleRequest(handler ProtocolHandler, p PDU) (PDU, error) {
+	fc := p.GetFunctionCode()
+	if cf, ok := customFunction(fc); ok {
+		return handleCustomFunction(cf, p)
+	}
+	if fc == FcReadFileRecord || fc == FcWriteFileRecord {
+		return handleFileRecordRequest(handler, p)
+	}
+	if !fc.IsWriteToServer() && !fc.IsReadToServer() {
+		return nil, ErrFcNotSupported
+	}
+	if fc.IsWriteToServer() {
+		data, err := p.GetRequestValues()
+		if err != nil {
+			debugf("p.GetRequestValues error:%v\n", err)
+			return nil, err
+		}
+		err = handler.OnWrite(p.writePart(), data)
+		if err != nil {
+			debugf("handler.OnWrite error:%v\n", err)
+			return nil, err
+		}
+	}
+	if fc.IsReadToServer() {
+		data, err := handler.OnRead(p.readPart())
+		if err != nil {
+			debugf("handler.OnRead error:%v\n", err)
+			return nil, err
+		}
+		if fc == FcReadFIFOQueue && len(data) > int(fc.MaxPerPacket())*2 {
+			debugf("FIFO count of %v is too large\n", len(data)/2)
+			return nil, EcIllegalDataValue
+		}
+		return p.MakeReadReply(data), nil
+	}
+	return p.MakeWriteReply(), nil
+}
@@ -0,0 +1,110 @@
+package modbusone
+
+import (
+	"fmt"
+)
+
+// ServerIDReply is the content of a FcReportServerID reply.
+type ServerIDReply struct {
+	ServerID       []byte // device specific, usually 1 byte
+	RunIndicator   bool
+	AdditionalData []byte
+}
+
+// ServerInfo answers FcReadExceptionStatus and FcReportServerID requests on a
+// server. The static values are used, unless the corresponding callback is set.
+type ServerInfo struct {
+	ExceptionStatus byte
+	ServerID        ServerIDReply
+
+	// ReadExceptionStatus, if not nil, is called to answer FcReadExceptionStatus.
+	ReadExceptionStatus func() (byte, error)
+	// ReportServerID, if not nil, is called to answer FcReportServerID.
+	ReportServerID func() (ServerIDReply, error)
+}
+
+// handleRequest answers FcReadExceptionStatus and FcReportServerID requests.
+func (s *ServerInfo) handleRequest(p PDU) (PDU, error) {
+	if s == nil {
+		return nil, ErrFcNotSupported
+	}
+	if len(p) != 1 {
+		return nil, EcIllegalDataValue
+	}
+	if p.GetFunctionCode() == FcReadExceptionStatus {
+		status := s.ExceptionStatus
+		if s.ReadExceptionStatus != nil {
+			var err error
+			status, err = s.ReadExceptionStatus()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return PDU{p[0], status}, nil
+	}
+	id := s.ServerID
+	if s.ReportServerID != nil {
+		var err error
+		id, err = s.ReportServerID()
+		if err != nil {
+			return nil, err
+		}
+	}
+	size := len(id.ServerID) + 1 + len(id.AdditionalData)
+	if size+2 > MaxPDUSize {
+		debugf("server id reply of %v bytes is too long\n", size+2)
+		return nil, EcServerDeviceFailure
+	}
+	reply := make(PDU, 0, size+2)
+	reply = append(reply, p[0], byte(size))
+	reply = append(reply, id.ServerID...)
+	if id.RunIndicator {
+		reply = append(reply, 0xFF)
+	} else {
+		reply = append(reply, 0x00)
+	}
+	return append(reply, id.AdditionalData...), nil
+}
+
+// GetServerIDReply parses a FcReportServerID reply. The length of the server
+// ID is device specific, and must be given by idLength.
+func (p PDU) GetServerIDReply(idLength int) (*ServerIDReply, error) {
+	if p.GetFunctionCode() != FcReportServerID || len(p) < 2 || int(p[1]) != len(p)-2 {
+		return nil, fmt.Errorf("not a report server id reply:%x", []byte(p))
+	}
+	if idLength < 0 || len(p) < 3+idLength {
+		return nil, fmt.Errorf("report server id reply too short for server id of %v bytes:%x", idLength, []byte(p))
+	}
+	run := p[2+idLength]
+	if run != 0x00 && run != 0xFF {
+		return nil, fmt.Errorf("run indicator status of 0x%02X is unknown", run)
+	}
+	return &ServerIDReply{
+		ServerID:       append([]byte(nil), p[2:2+idLength]...),
+		RunIndicator:   run == 0xFF,
+		AdditionalData: append([]byte(nil), p[3+idLength:]...),
+	}, nil
+}
+
+// ReadExceptionStatus reads the exception status byte from a server.
+func ReadExceptionStatus(c RawTransactor, slaveID byte) (byte, error) {
+	rp, err := c.DoRawTransaction(slaveID, PDU{byte(FcReadExceptionStatus)})
+	if err != nil {
+		return 0, err
+	}
+	if len(rp) != 2 {
+		return 0, fmt.Errorf("read exception status reply of %v bytes, expected 2", len(rp))
+	}
+	return rp[1], nil
+}
+
+// ReportServerID reads the server ID, run indicator status, and additional data
+// from a server. The length of the server ID is device specific, and must be
+// given by idLength.
+func ReportServerID(c RawTransactor, slaveID byte, idLength int) (*ServerIDReply, error) {
+	rp, err := c.DoRawTransaction(slaveID, PDU{byte(FcReportServerID)})
+	if err != nil {
+		return nil, err
+	}
+	return rp.GetServerIDReply(idLength)
+}
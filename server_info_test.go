@@ -0,0 +1,101 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestServerInfoRTU(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, cc := connectMockRTU(t, slaveID)
+
+	want := ServerIDReply{
+		ServerID:       []byte{0x42},
+		RunIndicator:   true,
+		AdditionalData: []byte("meter v1.2"),
+	}
+	server.ServerInfo = &ServerInfo{
+		ExceptionStatus: 0x6D,
+		ServerID:        want,
+	}
+
+	go client.Serve(&SimpleHandler{})
+	go server.Serve(&SimpleHandler{})
+
+	t.Run("read exception status", func(t *testing.T) {
+		status, err := ReadExceptionStatus(client, slaveID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != 0x6D {
+			t.Errorf("got status %x, expected 6D", status)
+		}
+		if !bytes.Equal(cc.LastWritten, RTU{0x11, 0x07, 0x4C, 0x22}) {
+			t.Errorf("request %x is not as expected", cc.LastWritten)
+		}
+	})
+	t.Run("report server id", func(t *testing.T) {
+		got, err := ReportServerID(client, slaveID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("got %+v, expected %+v", *got, want)
+		}
+		if !bytes.Equal(cc.LastWritten, RTU{0x11, 0x11, 0xCD, 0xEC}) {
+			t.Errorf("request %x is not as expected", cc.LastWritten)
+		}
+	})
+	t.Run("callback error", func(t *testing.T) {
+		server.ServerInfo.ReadExceptionStatus = func() (byte, error) {
+			return 0, EcServerDeviceBusy
+		}
+		_, err := ReadExceptionStatus(client, slaveID)
+		if err == nil {
+			t.Fatal("expected exception")
+		}
+		if errors.Is(err, ErrServerTimeOut) {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestServerInfoTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewTCPServer(listener)
+	defer server.Close()
+	server.ServerInfo = &ServerInfo{
+		ReportServerID: func() (ServerIDReply, error) {
+			return ServerIDReply{ServerID: []byte{1, 2}}, nil
+		},
+	}
+	go server.Serve(&SimpleHandler{})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewTCPClient(conn, 1)
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+
+	got, err := ReportServerID(client, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ServerIDReply{ServerID: []byte{1, 2}}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got %+v, expected %+v", *got, want)
+	}
+	if _, err := ReportServerID(client, 1, 3); err == nil {
+		t.Error("expected error for server id longer than the reply")
+	}
+}
@@ -2,6 +2,7 @@ package modbusone
 
 import (
 	"errors"
+	"sync"
 )
 
 // ErrFcNotSupported is another version of EcIllegalFunction, encountering of
@@ -27,13 +28,35 @@ type SimpleHandler struct {
 	// ReadDiscreteInputs handles client side FC=4
 	WriteInputRegisters func(address uint16, values []uint16) error
 
-	// ReadHoldingRegisters handles client side FC=6&16, server side FC=3
+	// ReadHoldingRegisters handles client side FC=6&16, server side FC=3, and
+	// server side FC=22 with WriteHoldingRegisters, see MaskWriteHoldingRegister
 	ReadHoldingRegisters func(address, quantity uint16) ([]uint16, error)
-	// WriteHoldingRegisters handles client side FC=3, server side FC=6&16
+	// WriteHoldingRegisters handles client side FC=3, server side FC=6&16&22
 	WriteHoldingRegisters func(address uint16, values []uint16) error
 
+	// ReadHoldingRegisterMasks handles client side FC=22, by providing the masks to send
+	ReadHoldingRegisterMasks func(address uint16) (andMask, orMask uint16, err error)
+	// MaskWriteHoldingRegister, if not nil, handles server side FC=22, the
+	// implementation must set the register to MaskRegister(current, andMask, orMask)
+	// atomically, as other masters may be writing to it too. If nil, FC=22 reads
+	// the register by ReadHoldingRegisters and writes it by WriteHoldingRegisters,
+	// while holding off the other writes of holding registers by this handler.
+	MaskWriteHoldingRegister func(address, andMask, orMask uint16) error
+
+	// ReadFIFOQueue handles server side FC=24, up to 31 values can be returned
+	ReadFIFOQueue func(pointerAddress uint16) ([]uint16, error)
+	// WriteFIFOQueue handles client side FC=24
+	WriteFIFOQueue func(pointerAddress uint16, values []uint16) error
+
+	// ReadFileRecord handles server side FC=20, exactly length values must be returned
+	ReadFileRecord func(file, record, length uint16) ([]uint16, error)
+	// WriteFileRecord handles server side FC=21
+	WriteFileRecord func(file, record uint16, values []uint16) error
+
 	// OnErrorImp handles OnError
 	OnErrorImp func(req PDU, errRep PDU)
+
+	holdingLock sync.Mutex // held by writes of holding registers
 }
 
 // OnRead is called by a Server, set Read... to catch the calls.
@@ -82,6 +105,24 @@ func (h *SimpleHandler) OnRead(req PDU) ([]byte, error) {
 			return nil, err
 		}
 		return RegistersToData(values)
+	case FcMaskWriteRegister:
+		if h.ReadHoldingRegisterMasks == nil {
+			return nil, ErrFcNotSupported
+		}
+		andMask, orMask, err := h.ReadHoldingRegisterMasks(address)
+		if err != nil {
+			return nil, err
+		}
+		return RegistersToData([]uint16{andMask, orMask})
+	case FcReadFIFOQueue:
+		if h.ReadFIFOQueue == nil {
+			return nil, ErrFcNotSupported
+		}
+		values, err := h.ReadFIFOQueue(address)
+		if err != nil {
+			return nil, err
+		}
+		return RegistersToData(values)
 	}
 	return nil, ErrFcNotSupported
 }
@@ -130,11 +171,71 @@ func (h *SimpleHandler) OnWrite(req PDU, data []byte) error {
 		if err != nil {
 			return err
 		}
+		h.holdingLock.Lock()
+		defer h.holdingLock.Unlock()
 		return h.WriteHoldingRegisters(address, values)
+	case FcMaskWriteRegister:
+		if h.MaskWriteHoldingRegister == nil && (h.ReadHoldingRegisters == nil || h.WriteHoldingRegisters == nil) {
+			return ErrFcNotSupported
+		}
+		values, err := DataToRegisters(data)
+		if err != nil {
+			return err
+		}
+		if len(values) != 2 {
+			return EcIllegalDataValue
+		}
+		if h.MaskWriteHoldingRegister != nil {
+			return h.MaskWriteHoldingRegister(address, values[0], values[1])
+		}
+		return h.maskWrite(address, values[0], values[1])
+	case FcReadFIFOQueue:
+		if h.WriteFIFOQueue == nil {
+			return ErrFcNotSupported
+		}
+		values := []uint16{} // the queue can be empty
+		if len(data) > 0 {
+			values, err = DataToRegisters(data)
+			if err != nil {
+				return err
+			}
+		}
+		return h.WriteFIFOQueue(address, values)
 	}
 	return ErrFcNotSupported
 }
 
+// maskWrite sets the holding register at address by MaskRegister, with
+// ReadHoldingRegisters and WriteHoldingRegisters.
+func (h *SimpleHandler) maskWrite(address, andMask, orMask uint16) error {
+	h.holdingLock.Lock()
+	defer h.holdingLock.Unlock()
+	current, err := h.ReadHoldingRegisters(address, 1)
+	if err != nil {
+		return err
+	}
+	if len(current) != 1 {
+		return EcServerDeviceFailure
+	}
+	return h.WriteHoldingRegisters(address, []uint16{MaskRegister(current[0], andMask, orMask)})
+}
+
+// OnReadFileRecord is called by a Server, set ReadFileRecord to catch the calls.
+func (h *SimpleHandler) OnReadFileRecord(file, record, length uint16) ([]uint16, error) {
+	if h.ReadFileRecord == nil {
+		return nil, ErrFcNotSupported
+	}
+	return h.ReadFileRecord(file, record, length)
+}
+
+// OnWriteFileRecord is called by a Server, set WriteFileRecord to catch the calls.
+func (h *SimpleHandler) OnWriteFileRecord(file, record uint16, values []uint16) error {
+	if h.WriteFileRecord == nil {
+		return ErrFcNotSupported
+	}
+	return h.WriteFileRecord(file, record, values)
+}
+
 // OnError is called by a Server, set OnErrorImp to catch the calls.
 func (h *SimpleHandler) OnError(req PDU, errRep PDU) {
 	if h.OnErrorImp == nil {
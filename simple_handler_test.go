@@ -0,0 +1,48 @@
+package modbusone_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestSimpleHandlerMaskWrite(t *testing.T) {
+	var lock sync.Mutex // of register only, not of the read-modify-write
+	register := uint16(0)
+	h := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			lock.Lock()
+			v := register
+			lock.Unlock()
+			time.Sleep(time.Millisecond) // let other mask writes interleave
+			return []uint16{v}, nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			lock.Lock()
+			defer lock.Unlock()
+			register = values[0]
+			return nil
+		},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(bit uint16) {
+			defer wg.Done()
+			req := PDU{byte(FcMaskWriteRegister), 0, 5, byte(^bit >> 8), byte(^bit), byte(bit >> 8), byte(bit)}
+			if err := h.OnWrite(req, req[3:]); err != nil {
+				t.Error(err)
+			}
+		}(1 << i)
+	}
+	wg.Wait()
+	if register != 0xFFFF {
+		t.Errorf("got register %x after setting each bit, expected ffff", register)
+	}
+
+	if err := (&SimpleHandler{}).OnWrite(PDU{byte(FcMaskWriteRegister), 0, 5, 0, 0, 0, 0}, []byte{0, 0, 0, 0}); err != ErrFcNotSupported {
+		t.Errorf("got %v, expected ErrFcNotSupported", err)
+	}
+}
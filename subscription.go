@@ -0,0 +1,289 @@
+package modbusone
+
+import (
+	"math"
+	"sync"
+)
+
+// Deadband limits the changes of analog values to notify. A change is notified
+// when the difference from the last notified value is more than Absolute, and
+// more than Percent percent of the last notified value. The zero Deadband
+// notifies all changes.
+type Deadband struct {
+	Absolute float64
+	Percent  float64
+	Signed   bool // values are int16
+}
+
+// exceeded returns true if the change from last to v is outside the deadband.
+func (d Deadband) exceeded(last, v uint16) bool {
+	if last == v {
+		return false
+	}
+	l, n := float64(last), float64(v)
+	if d.Signed {
+		l, n = float64(int16(last)), float64(int16(v))
+	}
+	diff := math.Abs(n - l)
+	return diff > d.Absolute && diff > math.Abs(l)*d.Percent/100
+}
+
+// Subscription subscribes to changes of a range of values of a slave.
+type Subscription struct {
+	SlaveID      byte
+	FunctionCode FunctionCode // one of FcReadCoils, FcReadDiscreteInputs, FcReadHoldingRegisters or FcReadInputRegisters
+	Address      uint16
+	Quantity     uint16
+	Deadband     Deadband // for registers
+	OnChange     func(c Change)
+}
+
+// Change is the notification of a Subscription.
+type Change struct {
+	// Values are the values of the subscribed range, as last notified.
+	// Coils and discrete inputs are 0 or 1.
+	Values []uint16
+	// Changed are the addresses of the changed values.
+	Changed []uint16
+	// Snapshot is true for the first notification with all values.
+	Snapshot bool
+	// Stale is the error of a failed poll, Values are not updated.
+	Stale error
+}
+
+type subscriber struct {
+	Subscription
+	values []uint16 // as last notified, nil before the snapshot
+	stale  bool
+}
+
+// notification is a Change to notify sb of, after unlocking Subscriptions.
+type notification struct {
+	sb *subscriber
+	c  Change
+}
+
+func (s *subscriber) overlaps(slaveID byte, fc FunctionCode, address, quantity uint16) bool {
+	return s.SlaveID == slaveID && s.FunctionCode == fc &&
+		uint32(address) < uint32(s.Address)+uint32(s.Quantity) &&
+		uint32(s.Address) < uint32(address)+uint32(quantity)
+}
+
+type valueKey struct {
+	slaveID byte
+	fc      FunctionCode
+	address uint16
+}
+
+// Subscriptions is a client side ProtocolHandler that notifies subscribers of
+// changes in the replies of polls, and passes all calls to Handler.
+//
+// A read reply passed to a handler does not tell which slave it is from, so
+// Subscriptions must be attached by Attach to a Poller, whose client must be a
+// RawContextTransactor such as RTUClient or TCPClient. Replies to other
+// transactions of the client are passed to Handler only.
+type Subscriptions struct {
+	// Handler, if not nil, also handles all calls, including the replies of polls.
+	Handler ProtocolHandler
+
+	lock   sync.Mutex
+	values map[valueKey]uint16 // the last read values
+	subs   []*subscriber
+}
+
+// Subscriptions is a ProtocolHandler.
+var _ ProtocolHandler = &Subscriptions{}
+
+// NewSubscriptions creates Subscriptions that also pass calls to handler, which
+// can be nil.
+func NewSubscriptions(handler ProtocolHandler) *Subscriptions {
+	return &Subscriptions{Handler: handler, values: make(map[valueKey]uint16)}
+}
+
+// Attach sets p to pass the replies and the failed polls to s. It must be called
+// before p runs.
+func (s *Subscriptions) Attach(p *Poller) {
+	reply, after := p.OnReply, p.OnPoll
+	p.OnReply = func(g PollGroup, req, rp PDU) error {
+		data, err := rp.GetReplyValues()
+		if err != nil {
+			return err
+		}
+		if err := s.readReply(g.SlaveID, req, data); err != nil {
+			return err
+		}
+		if s.Handler != nil {
+			if err := s.Handler.OnWrite(req.readPart(), data); err != nil {
+				return err
+			}
+		}
+		if reply != nil {
+			return reply(g, req, rp)
+		}
+		return nil
+	}
+	p.OnPoll = func(g PollGroup, err error) {
+		if err != nil {
+			s.stale(g, err)
+		}
+		if after != nil {
+			after(g, err)
+		}
+	}
+}
+
+// Subscribe adds sub, and returns the function to cancel it. A snapshot is
+// notified at once if all values are known.
+func (s *Subscriptions) Subscribe(sub Subscription) (cancel func()) {
+	sb := &subscriber{Subscription: sub}
+	s.lock.Lock()
+	s.subs = append(s.subs, sb)
+	c, ok := s.update(sb, nil)
+	s.lock.Unlock()
+	if ok {
+		sb.OnChange(c)
+	}
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for i, b := range s.subs {
+			if b == sb {
+				s.subs = append(s.subs[:i], s.subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// update returns the change of sb from the known values, for the changed
+// addresses, or all addresses if changed is nil.
+func (s *Subscriptions) update(sb *subscriber, changed map[uint16]bool) (Change, bool) {
+	if sb.values == nil {
+		values := make([]uint16, sb.Quantity)
+		for i := range values {
+			v, ok := s.values[valueKey{sb.SlaveID, sb.FunctionCode, sb.Address + uint16(i)}]
+			if !ok {
+				return Change{}, false
+			}
+			values[i] = v
+		}
+		sb.values = values
+		sb.stale = false
+		return Change{Values: append([]uint16(nil), values...), Snapshot: true}, true
+	}
+	var c Change
+	for i := range sb.values {
+		a := sb.Address + uint16(i)
+		if changed != nil && !changed[a] {
+			continue
+		}
+		v := s.values[valueKey{sb.SlaveID, sb.FunctionCode, a}]
+		if sb.FunctionCode.IsUint16() && !sb.Deadband.exceeded(sb.values[i], v) {
+			continue
+		}
+		if !sb.FunctionCode.IsUint16() && sb.values[i] == v {
+			continue
+		}
+		sb.values[i] = v
+		c.Changed = append(c.Changed, a)
+	}
+	if len(c.Changed) == 0 && !sb.stale {
+		return Change{}, false
+	}
+	sb.stale = false
+	c.Values = append([]uint16(nil), sb.values...)
+	return c, true
+}
+
+// stale notifies subscribers overlapping g of err.
+func (s *Subscriptions) stale(g PollGroup, err error) {
+	var notify []notification
+	s.lock.Lock()
+	for _, sb := range s.subs {
+		if sb.overlaps(g.SlaveID, g.FunctionCode, g.Address, g.Quantity) && !sb.stale {
+			sb.stale = true
+			notify = append(notify, notification{sb, Change{Values: append([]uint16(nil), sb.values...), Stale: err}})
+		}
+	}
+	s.lock.Unlock()
+	for _, n := range notify {
+		n.sb.OnChange(n.c)
+	}
+}
+
+// OnWrite passes the call to Handler.
+func (s *Subscriptions) OnWrite(req PDU, data []byte) error {
+	if s.Handler == nil {
+		return nil
+	}
+	return s.Handler.OnWrite(req, data)
+}
+
+// readReply updates the values from the reply of slaveID to req, and notifies
+// the subscribers of changes.
+func (s *Subscriptions) readReply(slaveID byte, req PDU, data []byte) error {
+	fc := req.GetFunctionCode()
+	if !fc.IsReadToServer() || fc == FcReadFIFOQueue {
+		return nil
+	}
+	address := req.GetAddress()
+	count, err := req.GetRequestCount()
+	if err != nil {
+		return err
+	}
+	var values []uint16
+	if fc.IsUint16() {
+		values, err = DataToRegisters(data)
+	} else {
+		var bs []bool
+		bs, err = DataToBools(data, count, fc)
+		values = make([]uint16, len(bs))
+		for i, b := range bs {
+			if b {
+				values[i] = 1
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var notify []notification
+	s.lock.Lock()
+	changed := make(map[uint16]bool)
+	for i, v := range values {
+		k := valueKey{slaveID, fc, address + uint16(i)}
+		if old, ok := s.values[k]; !ok || old != v {
+			changed[k.address] = true
+		}
+		s.values[k] = v
+	}
+	for _, sb := range s.subs {
+		if !sb.overlaps(slaveID, fc, address, uint16(len(values))) {
+			continue
+		}
+		if c, ok := s.update(sb, changed); ok {
+			notify = append(notify, notification{sb, c})
+		}
+	}
+	s.lock.Unlock()
+	for _, n := range notify {
+		n.sb.OnChange(n.c)
+	}
+	return nil
+}
+
+// OnRead passes the call to Handler.
+func (s *Subscriptions) OnRead(req PDU) ([]byte, error) {
+	if s.Handler == nil {
+		return nil, ErrFcNotSupported
+	}
+	return s.Handler.OnRead(req)
+}
+
+// OnError passes the call to Handler.
+func (s *Subscriptions) OnError(req PDU, errRep PDU) {
+	if s.Handler != nil {
+		s.Handler.OnError(req, errRep)
+	}
+}
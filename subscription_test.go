@@ -0,0 +1,163 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestSubscriptions(t *testing.T) {
+	slaveID := byte(0x11)
+	client, server, _ := connectMockRTU(t, slaveID)
+	client.SetServerProcessingTime(time.Second / 10)
+
+	var lock sync.Mutex
+	registers := []uint16{100, 200}
+	var failure error
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if failure != nil {
+				return nil, failure
+			}
+			return append([]uint16(nil), registers[address:address+quantity]...), nil
+		},
+	})
+	set := func(address, value uint16, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		registers[address] = value
+		failure = err
+	}
+
+	subs := NewSubscriptions(nil)
+	go client.Serve(subs)
+	poller := NewPoller(client)
+	poller.Jitter = 0
+	subs.Attach(poller)
+	if err := poller.Add(PollGroup{SlaveID: slaveID, FunctionCode: FcReadHoldingRegisters, Quantity: 2, Interval: time.Second / 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan Change, 10)
+	subs.Subscribe(Subscription{SlaveID: slaveID, FunctionCode: FcReadHoldingRegisters, Quantity: 2,
+		Deadband: Deadband{Absolute: 5}, OnChange: func(c Change) { changes <- c }})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go poller.Run(ctx)
+
+	expect := func(t *testing.T, changes chan Change, expected Change) {
+		t.Helper()
+		select {
+		case c := <-changes:
+			if !reflect.DeepEqual(c, expected) {
+				t.Errorf("got %+v, expected %+v", c, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change, expected %+v", expected)
+		}
+	}
+	expect(t, changes, Change{Values: []uint16{100, 200}, Snapshot: true})
+
+	set(0, 103, nil) // in deadband
+	time.Sleep(time.Second / 10)
+	set(1, 190, nil)
+	expect(t, changes, Change{Values: []uint16{100, 190}, Changed: []uint16{1}})
+
+	second := make(chan Change, 10)
+	cancelSecond := subs.Subscribe(Subscription{SlaveID: slaveID, FunctionCode: FcReadHoldingRegisters, Address: 1, Quantity: 1,
+		OnChange: func(c Change) { second <- c }})
+	expect(t, second, Change{Values: []uint16{190}, Snapshot: true})
+	cancelSecond()
+
+	set(0, 103, EcServerDeviceFailure)
+	select {
+	case c := <-changes:
+		if !errors.Is(c.Stale, EcServerDeviceFailure) || !reflect.DeepEqual(c.Values, []uint16{100, 190}) {
+			t.Errorf("got %+v, expected stale", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no stale notification")
+	}
+	set(0, 103, nil)
+	expect(t, changes, Change{Values: []uint16{100, 190}})
+	if len(second) != 0 {
+		t.Errorf("got %v changes after cancel", len(second))
+	}
+}
+
+func TestSubscriptionsOtherSlave(t *testing.T) {
+	client, bus := connectMockBus(t, 1)
+	client.SetServerProcessingTime(time.Second / 20)
+	// each slave replies with registers of its slave id
+	go func() {
+		b := make([]byte, MaxRTUSize)
+		for {
+			n, err := bus.Read(b)
+			if err != nil {
+				return
+			}
+			req, err := RTU(b[:n]).GetPDU()
+			if err != nil {
+				continue
+			}
+			count, err := req.GetRequestCount()
+			if err != nil {
+				continue
+			}
+			values := make([]uint16, count)
+			for i := range values {
+				values[i] = uint16(b[0])
+			}
+			data, _ := RegistersToData(values)
+			bus.Write(MakeRTU(b[0], append(PDU{byte(FcReadHoldingRegisters), byte(len(data))}, data...)))
+		}
+	}()
+
+	subs := NewSubscriptions(nil)
+	go client.Serve(subs)
+	poller := NewPoller(client)
+	poller.Jitter = 0
+	subs.Attach(poller)
+	if err := poller.Add(PollGroup{SlaveID: 1, FunctionCode: FcReadHoldingRegisters, Quantity: 250, Interval: time.Second / 50}); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan Change, 100)
+	subs.Subscribe(Subscription{SlaveID: 1, FunctionCode: FcReadHoldingRegisters, Quantity: 250,
+		OnChange: func(c Change) { changes <- c }})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go poller.Run(ctx)
+
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 125)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := client.DoTransactionContext(ctx, 2, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case c := <-changes:
+		if !c.Snapshot {
+			t.Errorf("got %+v, expected snapshot", c)
+		}
+		for _, v := range c.Values {
+			if v != 1 {
+				t.Fatalf("got value %v of slave 2 in the snapshot of slave 1", v)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no snapshot")
+	}
+	if len(changes) != 0 {
+		t.Errorf("got change %+v, expected none", <-changes)
+	}
+}
@@ -0,0 +1,305 @@
+package modbusone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SyncClient wraps a Client with synchronous methods that take and return
+// values, instead of calling a ProtocolHandler.
+//
+// Clients that implement RawContextTransactor or RawTransactor are used as is,
+// other clients are used through their ProtocolHandler. Transactions run one at
+// a time, except for clients that can pipeline them, such as TCPClient.
+//
+// Ranges of coils, inputs and registers too large for one request are split into
+// several requests as set by SetRangeOptions, and any failure is returned as a
+// *RangeError.
+type SyncClient struct {
+	client      Client
+	handler     syncHandler
+	turn        chan struct{} // taken to run a transaction, unless the client pipelines
+	optionsLock sync.Mutex
+	options     map[byte]RangeOptions
+}
+
+// NewSyncClient wraps client, which must be served by SyncClient.Serve.
+func NewSyncClient(client Client) *SyncClient {
+	return &SyncClient{client: client, turn: make(chan struct{}, 1)}
+}
+
+// Serve serves the wrapped client, see Client.Serve.
+func (c *SyncClient) Serve() error {
+	return c.client.Serve(&c.handler)
+}
+
+// Close closes the wrapped client.
+func (c *SyncClient) Close() error {
+	return c.client.Close()
+}
+
+// pipeliner is implemented by clients that can run raw transactions from
+// several go routines at the same time.
+type pipeliner interface {
+	pipelines()
+}
+
+// syncHandler is the ProtocolHandler of a SyncClient, that exchanges data with
+// the current transaction.
+type syncHandler struct {
+	lock   sync.Mutex
+	header PDU    // request header of the current transaction
+	data   []byte // data to write
+	values []byte // data read
+}
+
+func (h *syncHandler) start(header PDU, data []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.header, h.data, h.values = header, data, nil
+}
+
+func (h *syncHandler) result() []byte {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.values
+}
+
+// OnRead supplies the data of the current transaction, req is checked to ignore
+// calls of abandoned transactions.
+func (h *syncHandler) OnRead(req PDU) ([]byte, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !bytes.Equal(req, h.header.writePart()) {
+		return nil, fmt.Errorf("request %x is not of the current transaction", []byte(req))
+	}
+	return h.data, nil
+}
+
+// OnWrite keeps the data of the current transaction.
+func (h *syncHandler) OnWrite(req PDU, data []byte) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !bytes.Equal(req, h.header.readPart()) {
+		return fmt.Errorf("request %x is not of the current transaction", []byte(req))
+	}
+	h.values = append([]byte(nil), data...)
+	return nil
+}
+
+// OnError is a no-op, the exception is returned by the transaction.
+func (h *syncHandler) OnError(req, errRep PDU) {}
+
+// transact sends the request of header and data to slaveID, and returns the
+// data of a read reply.
+func (c *SyncClient) transact(ctx context.Context, slaveID byte, header PDU, data []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req := header
+	if header.GetFunctionCode().IsWriteToServer() {
+		req = header.MakeWriteRequest(data)
+	}
+	_, pipelines := c.client.(pipeliner)
+	_, rawContext := c.client.(RawContextTransactor)
+	_, raw := c.client.(RawTransactor)
+	if !pipelines || !(rawContext || raw) {
+		select {
+		case c.turn <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-c.turn }()
+	}
+	var rp PDU
+	var err error
+	switch rc := c.client.(type) {
+	case RawContextTransactor:
+		rp, err = rc.DoRawTransactionContext(ctx, slaveID, req)
+	case RawTransactor:
+		rp, err = rc.DoRawTransaction(slaveID, req)
+	default:
+		return c.transactByHandler(ctx, slaveID, header, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !header.GetFunctionCode().IsReadToServer() {
+		return nil, nil
+	}
+	if rp == nil {
+		return nil, errors.New("no reply to read from multicast")
+	}
+	return rp.GetReplyValues()
+}
+
+// transactByHandler is transact for clients that are used through the handler,
+// in the turn taken by transact.
+func (c *SyncClient) transactByHandler(ctx context.Context, slaveID byte, header PDU, data []byte) ([]byte, error) {
+	c.handler.start(header, data)
+	err := doTransactionContext(ctx, c.client, slaveID, header)
+	if err != nil {
+		return nil, err
+	}
+	return c.handler.result(), nil
+}
+
+// readBools reads count coils or discrete inputs.
+func (c *SyncClient) readBools(ctx context.Context, fc FunctionCode, slaveID byte, address, count uint16) ([]bool, error) {
+	values := make([]bool, count)
+	err := c.doRange(ctx, fc, slaveID, address, int(count), func(ctx context.Context, address uint16, offset, quantity int) error {
+		header, err := fc.MakeRequestHeader(address, uint16(quantity))
+		if err != nil {
+			return err
+		}
+		data, err := c.transact(ctx, slaveID, header, nil)
+		if err != nil {
+			return err
+		}
+		bs, err := DataToBools(data, uint16(quantity), fc)
+		if err != nil {
+			return err
+		}
+		copy(values[offset:], bs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// readRegisters reads count holding or input registers.
+func (c *SyncClient) readRegisters(ctx context.Context, fc FunctionCode, slaveID byte, address, count uint16) ([]uint16, error) {
+	values := make([]uint16, count)
+	err := c.doRange(ctx, fc, slaveID, address, int(count), func(ctx context.Context, address uint16, offset, quantity int) error {
+		header, err := fc.MakeRequestHeader(address, uint16(quantity))
+		if err != nil {
+			return err
+		}
+		data, err := c.transact(ctx, slaveID, header, nil)
+		if err != nil {
+			return err
+		}
+		if len(data) != quantity*2 {
+			return fmt.Errorf("got %v bytes, expected %v registers", len(data), quantity)
+		}
+		rs, err := DataToRegisters(data)
+		if err != nil {
+			return err
+		}
+		copy(values[offset:], rs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ReadCoils reads count coils from address.
+func (c *SyncClient) ReadCoils(ctx context.Context, slaveID byte, address, count uint16) ([]bool, error) {
+	return c.readBools(ctx, FcReadCoils, slaveID, address, count)
+}
+
+// ReadDiscreteInputs reads count discrete inputs from address.
+func (c *SyncClient) ReadDiscreteInputs(ctx context.Context, slaveID byte, address, count uint16) ([]bool, error) {
+	return c.readBools(ctx, FcReadDiscreteInputs, slaveID, address, count)
+}
+
+// ReadHoldingRegisters reads count holding registers from address.
+func (c *SyncClient) ReadHoldingRegisters(ctx context.Context, slaveID byte, address, count uint16) ([]uint16, error) {
+	return c.readRegisters(ctx, FcReadHoldingRegisters, slaveID, address, count)
+}
+
+// ReadInputRegisters reads count input registers from address.
+func (c *SyncClient) ReadInputRegisters(ctx context.Context, slaveID byte, address, count uint16) ([]uint16, error) {
+	return c.readRegisters(ctx, FcReadInputRegisters, slaveID, address, count)
+}
+
+// WriteSingleCoil writes value to the coil at address.
+func (c *SyncClient) WriteSingleCoil(ctx context.Context, slaveID byte, address uint16, value bool) error {
+	return c.writeBools(ctx, FcWriteSingleCoil, slaveID, address, []bool{value})
+}
+
+// WriteCoils writes values to the coils from address.
+func (c *SyncClient) WriteCoils(ctx context.Context, slaveID byte, address uint16, values []bool) error {
+	fc := FcWriteMultipleCoils
+	return c.doRange(ctx, fc, slaveID, address, len(values), func(ctx context.Context, address uint16, offset, quantity int) error {
+		return c.writeBools(ctx, fc, slaveID, address, values[offset:offset+quantity])
+	})
+}
+
+func (c *SyncClient) writeBools(ctx context.Context, fc FunctionCode, slaveID byte, address uint16, values []bool) error {
+	header, err := fc.MakeRequestHeader(address, uint16(len(values)))
+	if err != nil {
+		return err
+	}
+	data, err := BoolsToData(values, fc)
+	if err != nil {
+		return err
+	}
+	_, err = c.transact(ctx, slaveID, header, data)
+	return err
+}
+
+// WriteSingleRegister writes value to the holding register at address.
+func (c *SyncClient) WriteSingleRegister(ctx context.Context, slaveID byte, address uint16, value uint16) error {
+	return c.writeRegisters(ctx, FcWriteSingleRegister, slaveID, address, []uint16{value})
+}
+
+// WriteHoldingRegisters writes values to the holding registers from address.
+func (c *SyncClient) WriteHoldingRegisters(ctx context.Context, slaveID byte, address uint16, values []uint16) error {
+	fc := FcWriteMultipleRegisters
+	return c.doRange(ctx, fc, slaveID, address, len(values), func(ctx context.Context, address uint16, offset, quantity int) error {
+		return c.writeRegisters(ctx, fc, slaveID, address, values[offset:offset+quantity])
+	})
+}
+
+// MaskWriteRegister sets the holding register at address to
+// (current AND andMask) OR (orMask AND (NOT andMask)).
+func (c *SyncClient) MaskWriteRegister(ctx context.Context, slaveID byte, address uint16, andMask, orMask uint16) error {
+	return c.writeRegisters(ctx, FcMaskWriteRegister, slaveID, address, []uint16{andMask, orMask})
+}
+
+func (c *SyncClient) writeRegisters(ctx context.Context, fc FunctionCode, slaveID byte, address uint16, values []uint16) error {
+	quantity := uint16(len(values))
+	if fc.IsSingle() {
+		quantity = 1
+	}
+	header, err := fc.MakeRequestHeader(address, quantity)
+	if err != nil {
+		return err
+	}
+	data, err := RegistersToData(values)
+	if err != nil {
+		return err
+	}
+	_, err = c.transact(ctx, slaveID, header, data)
+	return err
+}
+
+// ReadWriteMultipleRegisters writes values to the holding registers from
+// writeAddress, then reads readCount holding registers from readAddress.
+func (c *SyncClient) ReadWriteMultipleRegisters(ctx context.Context, slaveID byte,
+	readAddress, readCount, writeAddress uint16, values []uint16) ([]uint16, error) {
+	header, err := MakeReadWriteRequestHeader(readAddress, readCount, writeAddress, uint16(len(values)))
+	if err != nil {
+		return nil, err
+	}
+	data, err := RegistersToData(values)
+	if err != nil {
+		return nil, err
+	}
+	rdata, err := c.transact(ctx, slaveID, header, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rdata) != int(readCount)*2 {
+		return nil, fmt.Errorf("got %v bytes, expected %v registers", len(rdata), readCount)
+	}
+	return DataToRegisters(rdata)
+}
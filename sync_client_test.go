@@ -0,0 +1,134 @@
+package modbusone_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+// newMemoryHandler returns a server handler of coils and holding registers in memory.
+func newMemoryHandler() *SimpleHandler {
+	var lock sync.Mutex
+	coils := make([]bool, 100)
+	registers := make([]uint16, 100)
+	return &SimpleHandler{
+		ReadCoils: func(address, quantity uint16) ([]bool, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]bool(nil), coils[address:address+quantity]...), nil
+		},
+		WriteCoils: func(address uint16, values []bool) error {
+			lock.Lock()
+			defer lock.Unlock()
+			copy(coils[address:], values)
+			return nil
+		},
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]uint16(nil), registers[address:address+quantity]...), nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			lock.Lock()
+			defer lock.Unlock()
+			copy(registers[address:], values)
+			return nil
+		},
+		MaskWriteHoldingRegister: func(address, andMask, orMask uint16) error {
+			lock.Lock()
+			defer lock.Unlock()
+			registers[address] = MaskRegister(registers[address], andMask, orMask)
+			return nil
+		},
+	}
+}
+
+// handlerOnlyClient hides the raw transactions of a Client.
+type handlerOnlyClient struct {
+	Client
+}
+
+func TestSyncClient(t *testing.T) {
+	slaveID := byte(0x11)
+	newClient := func(t *testing.T, hideRaw bool) *SyncClient {
+		rtu, server, _ := connectMockRTU(t, slaveID)
+		var client Client = rtu
+		if hideRaw {
+			client = handlerOnlyClient{client}
+		}
+		go server.Serve(newMemoryHandler())
+		c := NewSyncClient(client)
+		t.Cleanup(func() { c.Close() })
+		go c.Serve()
+		return c
+	}
+	for name, hideRaw := range map[string]bool{"raw": false, "handler": true} {
+		t.Run(name, func(t *testing.T) {
+			c := newClient(t, hideRaw)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			coils := []bool{true, false, true, true, false, false, false, false, true}
+			if err := c.WriteCoils(ctx, slaveID, 3, coils); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.WriteSingleCoil(ctx, slaveID, 4, true); err != nil {
+				t.Fatal(err)
+			}
+			coils[1] = true
+			got, err := c.ReadCoils(ctx, slaveID, 3, uint16(len(coils)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, coils) {
+				t.Errorf("got coils %v, expected %v", got, coils)
+			}
+
+			registers := []uint16{1, 2, 0x0012, 4}
+			if err := c.WriteHoldingRegisters(ctx, slaveID, 10, registers); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.WriteSingleRegister(ctx, slaveID, 10, 7); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.MaskWriteRegister(ctx, slaveID, 12, 0x00F2, 0x0025); err != nil {
+				t.Fatal(err)
+			}
+			registers[0], registers[2] = 7, 0x0017
+			rs, err := c.ReadHoldingRegisters(ctx, slaveID, 10, uint16(len(registers)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rs, registers) {
+				t.Errorf("got registers %v, expected %v", rs, registers)
+			}
+			rs, err = c.ReadWriteMultipleRegisters(ctx, slaveID, 13, 2, 14, []uint16{9})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rs, []uint16{4, 9}) {
+				t.Errorf("got registers %v after write, expected [4 9]", rs)
+			}
+			if _, err := c.ReadInputRegisters(ctx, slaveID, 0, 1); err == nil {
+				t.Error("expected exception for unsupported input registers")
+			}
+
+			errs := make(chan error, 4) // concurrent calls run one at a time
+			for i := 0; i < cap(errs); i++ {
+				go func() {
+					_, err := c.ReadHoldingRegisters(ctx, slaveID, 10, uint16(len(registers)))
+					errs <- err
+				}()
+			}
+			for i := 0; i < cap(errs); i++ {
+				if err := <-errs; err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
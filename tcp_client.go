@@ -2,31 +2,50 @@ package modbusone
 
 import (
 	"context"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // TCPClient implements Client/Master side logic for Modbus over a TCP connection to
 // be used by a ProtocolHandler.
+//
+// Replies are matched to requests by the MBAP transaction identifier, so up to
+// MaxOutstanding (see SetMaxOutstanding) transactions from different go routines
+// can be pipelined on one connection. Replies that do not match an outstanding
+// transaction are dropped and counted as OtherDrops in Stats.
+//
+// A transaction without a reply before Timeout returns a *TimeoutError, and its
+// reply is dropped if it arrives later. The client stays usable after time outs.
 type TCPClient struct {
+	stats         Stats           // first for 64 bit alignment
 	ctx           context.Context //nolint:containedctx // ctx is internally created.
 	cancle        context.CancelFunc
 	conn          io.ReadWriteCloser
 	SlaveID       byte
+	Timeout       time.Duration   // time to wait for a reply, or no time out if 0
+	Retry         RetryPolicy     // retries of failed transactions, see WithRetryPolicy for one transaction
 	_handler      ProtocolHandler // very private, always use getHandler
 	_handlerReady sync.WaitGroup
-	exitError     error // set this before call to cancel
-	locker        sync.Mutex
+	exitLock      sync.Mutex
+	exitError     error         // set by exit
+	writeLock     sync.Mutex    // one request is written at a time
+	slots         chan struct{} // holds a value for each outstanding transaction
+	pendingLock   sync.Mutex
+	pending       map[uint16]chan []byte // outstanding transactions by id
+	transactionID uint16                 // last used transaction id
 }
 
 // TCPClient is also a ServerCloser.
 var _ ServerCloser = &TCPClient{}
 
 // NewTCPClient create a new client communicating over a TCP connection with the
-// given slaveID as default.
+// given slaveID as default. Only one transaction is outstanding at a time,
+// unless changed by SetMaxOutstanding.
 func NewTCPClient(conn io.ReadWriteCloser, slaveID byte) *TCPClient {
 	ctx, cancle := context.WithCancel(context.Background())
 	c := &TCPClient{
@@ -34,21 +53,38 @@ func NewTCPClient(conn io.ReadWriteCloser, slaveID byte) *TCPClient {
 		cancle:  cancle,
 		conn:    conn,
 		SlaveID: slaveID,
+		Timeout: time.Second,
+		slots:   make(chan struct{}, 1),
+		pending: make(map[uint16]chan []byte),
 	}
 	c._handlerReady.Add(1)
+	go c.readLoop()
 	return c
 }
 
+// SetMaxOutstanding sets the max number of transactions waiting for replies at
+// the same time, n must be at least 1. Not all servers can handle more than one.
+//
+// SetMaxOutstanding must be called before the first transaction.
+func (c *TCPClient) SetMaxOutstanding(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.slots = make(chan struct{}, n)
+}
+
+// Stats returns the statistics of replies received.
+func (c *TCPClient) Stats() *Stats {
+	return &c.stats
+}
+
 // Serve serves TCPClient handlers.
 func (c *TCPClient) Serve(handler ProtocolHandler) error {
 	defer c.Close()
 	c._handler = handler // handler is used by calls from other go routines, so access needs to be synchronized.
 	c._handlerReady.Done()
 	<-c.ctx.Done()
-	if c.exitError != nil {
-		return c.exitError
-	}
-	return c.ctx.Err()
+	return c.getExitError()
 }
 
 func (c *TCPClient) getHandler() ProtocolHandler {
@@ -56,15 +92,58 @@ func (c *TCPClient) getHandler() ProtocolHandler {
 	return c._handler
 }
 
-// Close closes the client and closes the TCP connection.
-func (c *TCPClient) Close() error {
+// exit stops the client with err, the first err is kept.
+func (c *TCPClient) exit(err error) {
+	c.exitLock.Lock()
 	if c.exitError == nil {
-		c.exitError = errors.New("closed by user action")
+		c.exitError = err
 	}
+	c.exitLock.Unlock()
 	c.cancle()
+}
+
+func (c *TCPClient) getExitError() error {
+	c.exitLock.Lock()
+	defer c.exitLock.Unlock()
+	return c.exitError
+}
+
+// Close closes the client and closes the TCP connection.
+func (c *TCPClient) Close() error {
+	c.exit(errors.New("closed by user action"))
 	return c.conn.Close()
 }
 
+// readLoop reads replies and hands them to the outstanding transactions, until
+// there is an error reading from conn.
+func (c *TCPClient) readLoop() {
+	for {
+		var bs []byte
+		if OverSizeSupport {
+			bs = make([]byte, OverSizeMaxRTU+TCPHeaderLength)
+		} else {
+			bs = make([]byte, MaxRTUSize+TCPHeaderLength)
+		}
+		n, err := readTCP(c.conn, bs)
+		if err != nil {
+			c.exit(err)
+			return
+		}
+		atomic.AddInt64(&c.stats.ReadPackets, 1)
+		id := uint16(bs[0])<<8 | uint16(bs[1])
+		c.pendingLock.Lock()
+		reply, ok := c.pending[id]
+		delete(c.pending, id)
+		c.pendingLock.Unlock()
+		if !ok {
+			debugf("TCPClient drop reply with unknown transaction id %v: %x\n", id, bs[:n])
+			atomic.AddInt64(&c.stats.OtherDrops, 1)
+			continue
+		}
+		reply <- bs[:n]
+	}
+}
+
 // DoTransaction starts a transaction, and returns a channel that returns an error
 // or nil, with the default slaveID.
 //
@@ -78,54 +157,175 @@ func (c *TCPClient) DoTransaction(req PDU) error {
 
 // DoTransaction2 is DoTransaction with a settable slaveID.
 func (c *TCPClient) DoTransaction2(slaveID byte, req PDU) error {
-	c.locker.Lock() // only handle one transaction at a time for now
-	defer c.locker.Unlock()
-	var bs []byte
-	if OverSizeSupport {
-		bs = make([]byte, OverSizeMaxRTU+TCPHeaderLength)
-	} else {
-		bs = make([]byte, MaxRTUSize+TCPHeaderLength)
-	}
-	if req.GetFunctionCode().IsWriteToServer() {
-		data, err := c.getHandler().OnRead(req)
+	return c.DoTransactionContext(context.Background(), slaveID, req)
+}
+
+// DoTransactionContext is DoTransaction2 with a context. The transaction is
+// abandoned if ctx is done before it is sent, and the wait for a reply ends
+// when ctx is done or Timeout is reached, whichever is first.
+func (c *TCPClient) DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error {
+	_, err := c.doTransaction(ctx, slaveID, req, false)
+	return err
+}
+
+// DoRawTransaction sends req as is to slaveID, and returns the reply PDU, without
+// calling the handler. An exception reply is returned as an error.
+func (c *TCPClient) DoRawTransaction(slaveID byte, req PDU) (PDU, error) {
+	return c.DoRawTransactionContext(context.Background(), slaveID, req)
+}
+
+// DoRawTransactionContext is DoRawTransaction with a context, see DoTransactionContext.
+func (c *TCPClient) DoRawTransactionContext(ctx context.Context, slaveID byte, req PDU) (PDU, error) {
+	return c.doTransaction(ctx, slaveID, req, true)
+}
+
+// pipelines marks TCPClient as a pipeliner.
+func (c *TCPClient) pipelines() {}
+
+// TCPClient is also a RawTransactor and a RawContextTransactor.
+var (
+	_ RawTransactor        = &TCPClient{}
+	_ RawContextTransactor = &TCPClient{}
+)
+
+func (c *TCPClient) doTransaction(ctx context.Context, slaveID byte, req PDU, raw bool) (PDU, error) {
+	if req.GetFunctionCode().IsWriteToServer() && !raw {
+		data, err := c.getHandler().OnRead(req.writePart())
 		if err != nil {
-			return err
+			return nil, err
 		}
 		req = req.MakeWriteRequest(data)
 	}
-	_, err := writeTCP(c.conn, bs, req)
-	if err != nil {
-		c.exitError = err
-		c.cancle()
+	var rp PDU
+	err := retryPolicy(ctx, c.Retry).do(ctx, &c.stats, req, func() error {
+		var err error
+		rp, err = c.roundTrip(ctx, slaveID, req, raw)
 		return err
-	}
-	n, err := readTCP(c.conn, bs)
+	})
 	if err != nil {
-		c.exitError = err
-		c.cancle()
-		return err
-	}
-	rp := PDU(bs[MBAPHeaderLength:n])
-	hasErr, fc := rp.GetFunctionCode().SeparateError()
-	if hasErr {
-		c.getHandler().OnError(req, rp)
-		return fmt.Errorf("server reply with exception:%v", hex.EncodeToString(rp))
+		return nil, err
 	}
-	if !IsRequestReply(req, rp) {
-		err = errors.New("unexpected packet received")
-		c.exitError = err
-		c.cancle()
-		return err
+	if raw {
+		return rp, nil
 	}
-	if fc.IsReadToServer() {
+	if fc := req.GetFunctionCode(); fc.IsReadToServer() {
 		// read from server, write here
 		bs, err := rp.GetReplyValues()
 		if err != nil {
-			c.exitError = err
-			c.cancle()
+			atomic.AddInt64(&c.stats.OtherErrors, 1)
+			return nil, err
+		}
+		return nil, c.getHandler().OnWrite(req.readPart(), bs)
+	}
+	return nil, nil
+}
+
+// roundTrip exchanges req with slaveID once, and returns the reply PDU if it is
+// not an exception.
+func (c *TCPClient) roundTrip(ctx context.Context, slaveID byte, req PDU, raw bool) (PDU, error) {
+	bs, err := c.exchange(ctx, slaveID, req)
+	if err != nil {
+		return nil, err
+	}
+	if bs[TCPHeaderLength] != slaveID {
+		return nil, &SlaveIDMismatchError{SlaveID: slaveID, FunctionCode: req.GetFunctionCode(), Got: bs[TCPHeaderLength]}
+	}
+	rp := PDU(bs[MBAPHeaderLength:])
+	if hasErr, _ := rp.GetFunctionCode().SeparateError(); hasErr {
+		if !raw {
+			c.getHandler().OnError(req, rp)
+		}
+		return nil, exceptionReplyError(slaveID, rp)
+	}
+	if !IsRequestReply(req, rp) {
+		atomic.AddInt64(&c.stats.OtherErrors, 1)
+		return nil, &UnexpectedReplyError{SlaveID: slaveID, Request: req, Reply: rp}
+	}
+	return rp, nil
+}
+
+// exchange sends req to slaveID under a new transaction id, and returns the
+// reply ADU with the same id.
+func (c *TCPClient) exchange(ctx context.Context, slaveID byte, req PDU) ([]byte, error) {
+	slots := c.slots
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, c.getExitError()
+	}
+	defer func() { <-slots }()
+
+	reply := make(chan []byte, 1)
+	c.pendingLock.Lock()
+	id := c.transactionID + 1
+	for c.pending[id] != nil {
+		id++
+	}
+	c.transactionID = id
+	c.pending[id] = reply
+	c.pendingLock.Unlock()
+	defer func() {
+		c.pendingLock.Lock()
+		delete(c.pending, id)
+		c.pendingLock.Unlock()
+	}()
+
+	var timeOut <-chan time.Time
+	if c.Timeout > 0 {
+		timer := time.NewTimer(c.Timeout)
+		defer timer.Stop()
+		timeOut = timer.C
+	}
+	if err := c.write(ctx, id, slaveID, req); err != nil {
+		return nil, err
+	}
+	select {
+	case bs := <-reply:
+		return bs, nil
+	case <-timeOut:
+		debugf("TCPClient time out of transaction id %v\n", id)
+		return nil, &TimeoutError{SlaveID: slaveID, FunctionCode: req.GetFunctionCode()}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, c.getExitError()
+	}
+}
+
+// write writes req with transaction id to slaveID, under a write deadline of
+// Timeout, or the deadline of ctx if sooner, if conn supports it. A request not
+// written at all before the deadline returns a *TimeoutError, other errors stop
+// the client.
+func (c *TCPClient) write(ctx context.Context, id uint16, slaveID byte, req PDU) error {
+	bs := make([]byte, MBAPHeaderLength+len(req))
+	bs[0], bs[1] = byte(id>>8), byte(id)
+	bs[TCPHeaderLength] = slaveID // unit identifier
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	dc, hasDeadline := c.conn.(interface{ SetWriteDeadline(time.Time) error })
+	if hasDeadline {
+		var deadline time.Time
+		if c.Timeout > 0 {
+			deadline = time.Now().Add(c.Timeout)
+		}
+		if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+		if err := dc.SetWriteDeadline(deadline); err != nil {
+			c.exit(err)
 			return err
 		}
-		return c.getHandler().OnWrite(req, bs)
+	}
+	n, err := writeTCP(c.conn, bs, req)
+	if err != nil {
+		var ne net.Error
+		if n == 0 && errors.As(err, &ne) && ne.Timeout() {
+			return &TimeoutError{SlaveID: slaveID, FunctionCode: req.GetFunctionCode()}
+		}
+		c.exit(err)
+		return err
 	}
 	return nil
 }
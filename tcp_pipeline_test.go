@@ -0,0 +1,157 @@
+package modbusone_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestTCPClientPipeline(t *testing.T) {
+	const outstanding = 4
+	cc, sc := net.Pipe()
+	client := NewTCPClient(cc, 1)
+	client.SetMaxOutstanding(outstanding)
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+
+	// the server waits for all outstanding requests, then replies in reverse
+	// order with the starting address as value, after a reply to an unknown
+	// transaction.
+	go func() {
+		for {
+			var reqs [][]byte
+			for i := 0; i < outstanding; i++ {
+				b := make([]byte, 12)
+				if _, err := io.ReadFull(sc, b); err != nil {
+					return
+				}
+				reqs = append(reqs, b)
+			}
+			if _, err := sc.Write([]byte{0xFF, 0xFF, 0, 0, 0, 5, 1, 3, 2, 0, 0}); err != nil {
+				return
+			}
+			for i := len(reqs) - 1; i >= 0; i-- {
+				b := reqs[i]
+				if _, err := sc.Write([]byte{b[0], b[1], 0, 0, 0, 5, b[6], 3, 2, b[8], b[9]}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < outstanding*3; i++ {
+		wg.Add(1)
+		go func(address uint16) {
+			defer wg.Done()
+			req, err := FcReadHoldingRegisters.MakeRequestHeader(address, 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rp, err := client.DoRawTransaction(1, req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			values, err := rp.GetReplyValues()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := uint16(values[0])<<8 | uint16(values[1]); got != address {
+				t.Errorf("got reply for address %v, expected %v", got, address)
+			}
+		}(uint16(i))
+	}
+	wg.Wait()
+
+	stats := client.Stats()
+	if n := atomic.LoadInt64(&stats.ReadPackets); n != outstanding*3+3 {
+		t.Errorf("got %v read packets, expected %v", n, outstanding*3+3)
+	}
+	if n := atomic.LoadInt64(&stats.OtherDrops); n != 3 {
+		t.Errorf("got %v dropped replies, expected 3", n)
+	}
+}
+
+func TestTCPClientTimeout(t *testing.T) {
+	cc, sc := net.Pipe()
+	client := NewTCPClient(cc, 1)
+	client.Timeout = time.Second / 20
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+
+	// the server replies to the first request only after the second request.
+	go func() {
+		first := make([]byte, 12)
+		second := make([]byte, 12)
+		if _, err := io.ReadFull(sc, first); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(sc, second); err != nil {
+			return
+		}
+		for _, b := range [][]byte{first, second} {
+			if _, err := sc.Write([]byte{b[0], b[1], 0, 0, 0, 5, b[6], 3, 2, b[8], b[9]}); err != nil {
+				return
+			}
+		}
+	}()
+
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DoRawTransaction(1, req); !errors.Is(err, ErrServerTimeOut) {
+		t.Fatalf("got %v, expected time out", err)
+	}
+	req, err = FcReadHoldingRegisters.MakeRequestHeader(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := client.DoRawTransaction(1, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp[len(rp)-1] != 2 {
+		t.Errorf("got reply %x, expected the reply to the second request", rp)
+	}
+	if n := atomic.LoadInt64(&client.Stats().OtherDrops); n != 1 {
+		t.Errorf("got %v dropped replies, expected 1", n)
+	}
+}
+
+func TestTCPClientWriteTimeout(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	client := NewTCPClient(cc, 1)
+	client.Timeout = time.Second / 20
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+
+	// nothing reads from sc, so the request can not be written.
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DoRawTransaction(1, req); !errors.Is(err, ErrServerTimeOut) {
+		t.Fatalf("got %v, expected time out", err)
+	}
+	go func() {
+		b := make([]byte, 12)
+		if _, err := io.ReadFull(sc, b); err != nil {
+			return
+		}
+		sc.Write([]byte{b[0], b[1], 0, 0, 0, 5, b[6], 3, 2, 0, 0})
+	}()
+	if _, err := client.DoRawTransaction(1, req); err != nil {
+		t.Fatalf("client did not recover from write time out: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 )
 
 const (
@@ -13,10 +14,34 @@ const (
 
 // TCPServer implements Server/Slave side logic for Modbus over TCP to
 // be used by a ProtocolHandler.
+//
+// Requests are dispatched by the unit identifier of the MBAP header to the
+// handlers set by SetUnitHandler, or to the default handler given to Serve.
 type TCPServer struct {
-	listener net.Listener
+	listener  net.Listener
+	unitsLock sync.RWMutex
+	units     map[byte]ProtocolHandler
+
+	// UnknownUnit sets how to answer requests to unit identifiers without a
+	// handler, when there is no default handler.
+	UnknownUnit UnknownUnitReply
+
+	// DeviceIdentity answers Read Device Identification requests, if not nil.
+	DeviceIdentity *DeviceIdentity
+	// ServerInfo answers Read Exception Status and Report Server ID requests, if not nil.
+	ServerInfo *ServerInfo
 }
 
+// UnknownUnitReply is how a TCPServer answers requests to unknown units.
+type UnknownUnitReply int
+
+const (
+	// UnknownUnitGatewayException replies with EcGatewayPathUnavailable.
+	UnknownUnitGatewayException UnknownUnitReply = iota
+	// UnknownUnitNoReply does not reply, as a missing serial slave behind a gateway.
+	UnknownUnitNoReply
+)
+
 // NewTCPServer runs TCP server.
 func NewTCPServer(listener net.Listener) *TCPServer {
 	s := TCPServer{
@@ -34,7 +59,7 @@ func readTCP(r io.Reader, bs []byte) (n int, err error) {
 		return n, fmt.Errorf("MBAP protocol of %X %X is unknown", bs[2], bs[3])
 	}
 	l := int(bs[4])*256 + int(bs[5])
-	if l <= 2 {
+	if l < 2 { // unit id and function code
 		return n, fmt.Errorf("MBAP data length of %v is too short, bs:%x", l, bs[:n])
 	}
 	if len(bs) < l+TCPHeaderLength {
@@ -53,8 +78,36 @@ func writeTCP(w io.Writer, bs []byte, pdu PDU) (int, error) {
 	return w.Write(bs[:len(pdu)+MBAPHeaderLength])
 }
 
+// SetUnitHandler sets the handler for requests to unitID, or removes it if
+// handler is nil. It can be called while the server is running.
+func (s *TCPServer) SetUnitHandler(unitID byte, handler ProtocolHandler) {
+	s.unitsLock.Lock()
+	defer s.unitsLock.Unlock()
+	if handler == nil {
+		delete(s.units, unitID)
+		return
+	}
+	if s.units == nil {
+		s.units = make(map[byte]ProtocolHandler)
+	}
+	s.units[unitID] = handler
+}
+
+// unitHandler returns the handler for unitID, or defaultHandler.
+func (s *TCPServer) unitHandler(unitID byte, defaultHandler ProtocolHandler) ProtocolHandler {
+	s.unitsLock.RLock()
+	defer s.unitsLock.RUnlock()
+	if h, ok := s.units[unitID]; ok {
+		return h
+	}
+	return defaultHandler
+}
+
 // Serve runs the server and only returns after a connection or data error occurred.
 // The underling connection is always closed before this function returns.
+//
+// handler is the default handler for units without a handler set by
+// SetUnitHandler, it can be nil to only answer those units.
 func (s *TCPServer) Serve(handler ProtocolHandler) error {
 	defer s.Close()
 
@@ -90,36 +143,39 @@ func (s *TCPServer) Serve(handler ProtocolHandler) error {
 					return
 				}
 
-				fc := p.GetFunctionCode()
-				if fc.IsReadToServer() {
-					data, err := handler.OnRead(p)
-					if err != nil {
-						debugf("TCPServer handler.OnOutput error:%v\n", err)
-						wec(conn, rb, p, err)
-						continue
-					}
-					writeTCP(conn, rb, p.MakeReadReply(data))
-				} else if fc.IsWriteToServer() {
-					data, err := p.GetRequestValues()
-					if err != nil {
-						debugf("p:%v\n", p)
-						debugf("TCPServer p.GetRequestValues error:%v\n", err)
-						wec(conn, rb, p, err)
-						continue
+				h := s.unitHandler(rb[TCPHeaderLength], handler)
+				if h == nil {
+					debugf("TCPServer unknown unit:%v\n", rb[TCPHeaderLength])
+					if s.UnknownUnit == UnknownUnitGatewayException {
+						wec(conn, rb, p, EcGatewayPathUnavailable)
 					}
-					err = handler.OnWrite(p, data)
-					if err != nil {
-						debugf("TCPServer handler.OnInput error:%v\n", err)
-						wec(conn, rb, p, err)
-						continue
-					}
-					writeTCP(conn, rb, p.MakeWriteReply())
+					continue
+				}
+				reply, err := s.handleRequest(h, p)
+				if err != nil {
+					debugf("TCPServer handleRequest error:%v\n", err)
+					wec(conn, rb, p, err)
+					continue
+				}
+				if reply == nil {
+					continue // no reply
 				}
+				writeTCP(conn, rb, reply)
 			}
 		}(conn)
 	}
 }
 
+func (s *TCPServer) handleRequest(handler ProtocolHandler, p PDU) (PDU, error) {
+	switch p.GetFunctionCode() {
+	case FcEncapsulatedInterface:
+		return s.DeviceIdentity.handleRequest(p)
+	case FcReadExceptionStatus, FcReportServerID:
+		return s.ServerInfo.handleRequest(p)
+	}
+	return handleRequest(handler, p)
+}
+
 // Close closes the server and closes the listener.
 func (s *TCPServer) Close() error {
 	return s.listener.Close()
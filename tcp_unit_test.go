@@ -0,0 +1,113 @@
+package modbusone_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestTCPUnitID(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewTCPServer(listener)
+	defer server.Close()
+	unit := func(v uint16) ProtocolHandler {
+		return &SimpleHandler{
+			ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+				return []uint16{v}, nil
+			},
+		}
+	}
+	server.SetUnitHandler(1, unit(101))
+	server.SetUnitHandler(2, unit(102))
+	go server.Serve(nil)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewTCPClient(conn, 1)
+	defer client.Close()
+	var got uint16
+	go client.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			got = values[0]
+			return nil
+		},
+	})
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("dispatch", func(t *testing.T) {
+		for _, id := range []byte{1, 2, 1} {
+			if err := client.DoTransaction2(id, req); err != nil {
+				t.Fatal(err)
+			}
+			if got != 100+uint16(id) {
+				t.Errorf("got %v from unit %v", got, id)
+			}
+		}
+	})
+	t.Run("gateway exception", func(t *testing.T) {
+		rp, err := client.DoRawTransaction(3, req)
+		if err == nil {
+			t.Fatalf("got %x, expected exception", rp)
+		}
+	})
+	t.Run("no reply", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := NewTCPServer(listener)
+		defer server.Close()
+		server.UnknownUnit = UnknownUnitNoReply
+		server.SetUnitHandler(1, unit(101))
+		go server.Serve(nil)
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte{0, 1, 0, 0, 0, 6, 3, 3, 0, 0, 0, 1}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second / 10))
+		var ne net.Error
+		if _, err := conn.Read(make([]byte, 20)); !errors.As(err, &ne) || !ne.Timeout() {
+			t.Fatalf("got %v, expected no reply", err)
+		}
+	})
+}
+
+func TestTCPClientUnitIDMismatch(t *testing.T) {
+	cc, sc := net.Pipe()
+	client := NewTCPClient(cc, 5)
+	defer client.Close()
+	go client.Serve(&SimpleHandler{})
+	go func() {
+		b := make([]byte, 12)
+		if _, err := sc.Read(b); err != nil {
+			return
+		}
+		if b[6] != 5 {
+			t.Errorf("request to unit %v, expected 5", b[6])
+		}
+		sc.Write([]byte{b[0], b[1], 0, 0, 0, 5, 6, 3, 2, 0, 0}) // reply from unit 6
+	}()
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DoRawTransaction(5, req); err == nil {
+		t.Fatal("expected error for reply from another unit")
+	}
+}
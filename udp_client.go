@@ -0,0 +1,241 @@
+package modbusone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPClient implements Client/Master side logic for Modbus over UDP, with one
+// MBAP framed PDU per datagram, to be used by a ProtocolHandler.
+//
+// A request is retransmitted with the same transaction identifier as allowed by
+// Retry, such as when no reply is received before Timeout. By default, reads are
+// sent up to 3 times, and writes are not retransmitted. Replies are matched by
+// transaction identifier, so late replies to other transactions are dropped.
+type UDPClient struct {
+	stats         Stats           // first for 64 bit alignment
+	ctx           context.Context //nolint:containedctx // ctx is internally created.
+	cancle        context.CancelFunc
+	conn          net.Conn
+	SlaveID       byte
+	Timeout       time.Duration // time to wait for a reply of each attempt
+	Retry         RetryPolicy   // retransmissions of requests
+	_handler      ProtocolHandler
+	_handlerReady sync.WaitGroup
+	exitLock      sync.Mutex
+	exitError     error // set by exit
+	locker        sync.Mutex
+	transactionID uint16
+}
+
+// UDPClient is also a ServerCloser and a RawTransactor.
+var (
+	_ ServerCloser  = &UDPClient{}
+	_ RawTransactor = &UDPClient{}
+)
+
+// NewUDPClient create a new client communicating over a connected UDP socket
+// (such as from net.Dial("udp", address)) with the given slaveID as default.
+func NewUDPClient(conn net.Conn, slaveID byte) *UDPClient {
+	ctx, cancle := context.WithCancel(context.Background())
+	c := &UDPClient{
+		ctx:     ctx,
+		cancle:  cancle,
+		conn:    conn,
+		SlaveID: slaveID,
+		Timeout: time.Second,
+		Retry:   RetryPolicy{MaxAttempts: 3},
+	}
+	c._handlerReady.Add(1)
+	return c
+}
+
+// Stats returns the statistics of retransmissions.
+func (c *UDPClient) Stats() *Stats {
+	return &c.stats
+}
+
+// Serve serves UDPClient handlers.
+func (c *UDPClient) Serve(handler ProtocolHandler) error {
+	defer c.Close()
+	c._handler = handler
+	c._handlerReady.Done()
+	<-c.ctx.Done()
+	return c.getExitError()
+}
+
+func (c *UDPClient) getHandler() ProtocolHandler {
+	c._handlerReady.Wait()
+	return c._handler
+}
+
+// exit stops the client with err, the first err is kept.
+func (c *UDPClient) exit(err error) {
+	c.exitLock.Lock()
+	if c.exitError == nil {
+		c.exitError = err
+	}
+	c.exitLock.Unlock()
+	c.cancle()
+}
+
+func (c *UDPClient) getExitError() error {
+	c.exitLock.Lock()
+	defer c.exitLock.Unlock()
+	return c.exitError
+}
+
+// Close closes the client and closes the UDP socket.
+func (c *UDPClient) Close() error {
+	c.exit(errors.New("closed by user action"))
+	return c.conn.Close()
+}
+
+// DoTransaction starts a transaction, and returns a channel that returns an error
+// or nil, with the default slaveID.
+//
+// DoTransaction is blocking.
+//
+// For read from server, the PDU is sent as is (after been warped up in MBAP)
+// For write to server, the data part given will be ignored, and filled in by data from handler.
+func (c *UDPClient) DoTransaction(req PDU) error {
+	return c.DoTransaction2(c.SlaveID, req)
+}
+
+// DoTransaction2 is DoTransaction with a settable slaveID.
+func (c *UDPClient) DoTransaction2(slaveID byte, req PDU) error {
+	_, err := c.doTransaction(slaveID, req, false)
+	return err
+}
+
+// DoRawTransaction sends req as is to slaveID, and returns the reply PDU, without
+// calling the handler. An exception reply is returned as an error.
+func (c *UDPClient) DoRawTransaction(slaveID byte, req PDU) (PDU, error) {
+	return c.doTransaction(slaveID, req, true)
+}
+
+// StartTransactionToServer starts a transaction, with a custom slaveID.
+// errChan is required, an error is set if the transaction failed, or
+// nil for success.
+//
+// StartTransactionToServer is not blocking.
+func (c *UDPClient) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
+	go func() {
+		errChan <- c.DoTransaction2(slaveID, req)
+	}()
+}
+
+func (c *UDPClient) doTransaction(slaveID byte, req PDU, raw bool) (PDU, error) {
+	c.locker.Lock() // only handle one transaction at a time
+	defer c.locker.Unlock()
+	if req.GetFunctionCode().IsWriteToServer() && !raw {
+		data, err := c.getHandler().OnRead(req.writePart())
+		if err != nil {
+			return nil, err
+		}
+		req = req.MakeWriteRequest(data)
+	}
+	rp, err := c.exchange(slaveID, req)
+	if err != nil {
+		if rp != nil && !raw {
+			c.getHandler().OnError(req, rp)
+		}
+		return nil, err
+	}
+	if raw {
+		return rp, nil
+	}
+	if req.GetFunctionCode().IsReadToServer() {
+		// read from server, write here
+		bs, err := rp.GetReplyValues()
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.getHandler().OnWrite(req.readPart(), bs)
+	}
+	return nil, nil
+}
+
+// exchange sends req, with retransmissions, and returns the matching reply. An
+// exception reply is returned with its error.
+func (c *UDPClient) exchange(slaveID byte, req PDU) (PDU, error) {
+	c.transactionID++
+	id := c.transactionID
+	bs := make([]byte, MBAPHeaderLength+MaxPDUSize)
+	bs[0], bs[1] = byte(id>>8), byte(id)
+	bs[TCPHeaderLength] = slaveID // unit identifier
+	rb := make([]byte, MBAPHeaderLength+MaxPDUSize+1)
+	var rp PDU
+	err := c.Retry.do(c.ctx, &c.stats, req, func() error {
+		var err error
+		rp, err = c.attempt(bs, rb, id, slaveID, req)
+		if err != nil {
+			return err
+		}
+		if hasErr, _ := rp.GetFunctionCode().SeparateError(); hasErr {
+			return exceptionReplyError(slaveID, rp)
+		}
+		return nil
+	})
+	return rp, err
+}
+
+// attempt sends req in bs once, and returns the matching reply read into rb.
+func (c *UDPClient) attempt(bs, rb []byte, id uint16, slaveID byte, req PDU) (PDU, error) {
+	if _, err := writeTCP(c.conn, bs, req); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.Timeout)
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for {
+		n, err := c.conn.Read(rb)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				debugf("UDPClient time out of transaction id %v\n", id)
+				return nil, &TimeoutError{SlaveID: slaveID, FunctionCode: req.GetFunctionCode()}
+			}
+			return nil, err
+		}
+		rp, err := c.readReply(rb[:n], id, slaveID, req)
+		if err != nil {
+			debugf("UDPClient drop datagram %x: %v\n", rb[:n], err)
+			continue
+		}
+		return rp, nil
+	}
+}
+
+// readReply returns the PDU of a datagram if it is a reply to req with
+// transaction id to unit slaveID.
+func (c *UDPClient) readReply(datagram []byte, id uint16, slaveID byte, req PDU) (PDU, error) {
+	bs := make([]byte, MBAPHeaderLength+MaxPDUSize)
+	n, err := readTCP(bytes.NewReader(datagram), bs)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(datagram) {
+		return nil, fmt.Errorf("datagram of %v bytes has MBAP length of %v", len(datagram), n)
+	}
+	if got := uint16(bs[0])<<8 | uint16(bs[1]); got != id {
+		return nil, fmt.Errorf("transaction id %v, expected %v", got, id)
+	}
+	if bs[TCPHeaderLength] != slaveID {
+		return nil, fmt.Errorf("reply from unit %v, expected unit %v", bs[TCPHeaderLength], slaveID)
+	}
+	rp := PDU(bs[MBAPHeaderLength:n])
+	if !MatchPDU(req, rp) {
+		return nil, fmt.Errorf("unexpected reply to %x", []byte(req))
+	}
+	if ec, _ := rp.GetFunctionCode().SeparateError(); !ec && !IsRequestReply(req, rp) {
+		return nil, fmt.Errorf("unexpected reply to %x", []byte(req))
+	}
+	return rp, nil
+}
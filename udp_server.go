@@ -0,0 +1,89 @@
+package modbusone
+
+import (
+	"bytes"
+	"net"
+)
+
+// UDPServer implements Server/Slave side logic for Modbus over UDP to be used
+// by a ProtocolHandler. Each request datagram is replied to its source address.
+type UDPServer struct {
+	conn net.PacketConn
+
+	// DeviceIdentity answers Read Device Identification requests, if not nil.
+	DeviceIdentity *DeviceIdentity
+	// ServerInfo answers Read Exception Status and Report Server ID requests, if not nil.
+	ServerInfo *ServerInfo
+}
+
+// NewUDPServer creates a UDP server on conn, such as from net.ListenPacket("udp", address).
+func NewUDPServer(conn net.PacketConn) *UDPServer {
+	return &UDPServer{conn: conn}
+}
+
+// udpReplyWriter writes datagrams to addr.
+type udpReplyWriter struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+func (w udpReplyWriter) Write(b []byte) (int, error) {
+	return w.conn.WriteTo(b, w.addr)
+}
+
+// Serve runs the server and only returns after an error reading from conn,
+// such as conn is closed.
+func (s *UDPServer) Serve(handler ProtocolHandler) error {
+	defer s.Close()
+
+	wec := func(w udpReplyWriter, bs []byte, req PDU, err error) {
+		writeTCP(w, bs, ExceptionReplyPacket(req, ToExceptionCode(err)))
+	}
+
+	datagram := make([]byte, MBAPHeaderLength+MaxPDUSize+1)
+	rb := make([]byte, MBAPHeaderLength+MaxPDUSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(datagram)
+		if err != nil {
+			return err
+		}
+		w := udpReplyWriter{conn: s.conn, addr: addr}
+		m, err := readTCP(bytes.NewReader(datagram[:n]), rb)
+		if err != nil || m != n {
+			debugf("UDPServer drop datagram from %v:%x %v\n", addr, datagram[:n], err)
+			continue
+		}
+		p := PDU(rb[MBAPHeaderLength:m])
+		err = p.ValidateRequest()
+		if err != nil {
+			debugf("UDPServer ValidateRequest %v\n", err)
+			wec(w, rb, p, err)
+			continue
+		}
+		reply, err := s.handleRequest(handler, p)
+		if err != nil {
+			debugf("UDPServer handleRequest error:%v\n", err)
+			wec(w, rb, p, err)
+			continue
+		}
+		if reply == nil {
+			continue // no reply
+		}
+		writeTCP(w, rb, reply)
+	}
+}
+
+func (s *UDPServer) handleRequest(handler ProtocolHandler, p PDU) (PDU, error) {
+	switch p.GetFunctionCode() {
+	case FcEncapsulatedInterface:
+		return s.DeviceIdentity.handleRequest(p)
+	case FcReadExceptionStatus, FcReportServerID:
+		return s.ServerInfo.handleRequest(p)
+	}
+	return handleRequest(handler, p)
+}
+
+// Close closes the server and closes the connection.
+func (s *UDPServer) Close() error {
+	return s.conn.Close()
+}
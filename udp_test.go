@@ -0,0 +1,147 @@
+package modbusone_test
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewUDPServer(pc)
+	defer server.Close()
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return make([]uint16, quantity), nil
+		},
+	})
+
+	newClient := func(t *testing.T) *UDPClient {
+		conn, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewUDPClient(conn, 1)
+	}
+	var got []uint16
+	clientHandler := &SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			got = values
+			return nil
+		},
+	}
+
+	t.Run("read holding registers", func(t *testing.T) {
+		c1, c2 := newClient(t), newClient(t)
+		defer c1.Close()
+		defer c2.Close()
+		go c1.Serve(clientHandler)
+		go c2.Serve(clientHandler)
+		for i, c := range []*UDPClient{c1, c2, c1} {
+			req, err := FcReadHoldingRegisters.MakeRequestHeader(0, uint16(i+1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.DoTransaction(req); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != i+1 {
+				t.Fatalf("got %v values, expected %v", len(got), i+1)
+			}
+		}
+	})
+	t.Run("exception", func(t *testing.T) {
+		c := newClient(t)
+		defer c.Close()
+		go c.Serve(clientHandler)
+		req, err := FcReadCoils.MakeRequestHeader(0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DoTransaction(req); err == nil || errors.Is(err, ErrServerTimeOut) {
+			t.Fatalf("got %v, expected exception", err)
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		c := newClient(t)
+		served := make(chan error, 1)
+		go func() { served <- c.Serve(clientHandler) }()
+		go c.Close()
+		go c.Close()
+		if err := <-served; err == nil {
+			t.Error("expected Serve to return the close error")
+		}
+	})
+}
+
+func TestUDPRetransmission(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewUDPClient(conn, 1)
+	defer c.Close()
+	c.Timeout = time.Second / 20
+	c.Retry = RetryPolicy{MaxAttempts: 3}
+
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan int, 10)
+	go func() {
+		b := make([]byte, 300)
+		for count := 1; ; count++ {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			received <- count
+			if count == 5 {
+				// reply to the retransmission of the second transaction, first
+				// with a late reply to an earlier attempt
+				d := b[:n]
+				late := []byte{d[0], d[1] - 1, 0, 0, 0, 5, d[6], 3, 2, 0, 0}
+				pc.WriteTo(late, addr)
+				pc.WriteTo([]byte{d[0], d[1], 0, 0, 0, 5, d[6], 3, 2, 0, 7}, addr)
+			}
+		}
+	}()
+
+	if _, err := c.DoRawTransaction(1, req); !errors.Is(err, ErrServerTimeOut) {
+		t.Fatalf("got %v, expected time out", err)
+	}
+	if len(received) != 3 {
+		t.Fatalf("server received %v datagrams, expected 3", len(received))
+	}
+	rp, err := c.DoRawTransaction(1, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp[len(rp)-1] != 7 {
+		t.Errorf("got reply %x, expected the one with the current transaction id", rp)
+	}
+	if got := atomic.LoadInt64(&c.Stats().Retries); got != 3 {
+		t.Errorf("got %v retries in stats, expected 3", got)
+	}
+
+	write := PDU{byte(FcWriteSingleRegister), 0, 0, 0, 1}
+	if _, err := c.DoRawTransaction(1, write); !errors.Is(err, ErrServerTimeOut) {
+		t.Fatalf("got %v, expected time out", err)
+	}
+	if len(received) != 6 {
+		t.Errorf("server received %v datagrams, expected the write not retransmitted", len(received))
+	}
+}